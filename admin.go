@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	serverName    = "simple-mcp-server"
+	serverVersion = "1.0.0"
+)
+
+// Build information, injected at build time:
+//
+//	go build -ldflags "-X main.gitCommit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	gitCommit = ""
+	buildDate = ""
+)
+
+// versionInfo returns the server name, version and build information.
+// Without ldflags, the commit falls back to the VCS revision recorded by the Go toolchain, or "unknown".
+func versionInfo() map[string]any {
+	commit, date := gitCommit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && commit == "":
+				commit = setting.Value
+			case setting.Key == "vcs.time" && date == "":
+				date = setting.Value
+			}
+		}
+	}
+	if commit == "" {
+		commit = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	return map[string]any{
+		"name":       serverName,
+		"version":    serverVersion,
+		"go_version": runtime.Version(),
+		"git_commit": commit,
+		"build_date": date,
+	}
+}
+
+// HandleVersion returns the build and runtime information of the running server (no authorization required)
+func HandleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	info := versionInfo()
+	info["uptime"] = time.Since(stats.startTime).Round(time.Second).String()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// HandleHealthz reports liveness: 200 whenever the process is serving requests (no authorization required)
+func HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// HandleReadyz reports readiness: 200 once tokens can be validated, 503 otherwise (no authorization required).
+// With LazyJWKS the probe never fetches the JWKS; the first token does.
+func (c *OAuthConfig) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if reason := c.notReadyReason(r.Context()); reason != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "unavailable", "reason": reason})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// notReadyReason explains why the server cannot serve MCP requests yet, or returns "" when it can
+func (c *OAuthConfig) notReadyReason(ctx context.Context) string {
+	if maintenanceMode.Load() {
+		return "maintenance mode"
+	}
+	if warmingUp.Load() {
+		return "warming up"
+	}
+	if !c.hasJWKS() {
+		// Every token is introspected; there is no key set to wait for
+		return ""
+	}
+	jwks := c.currentJWKS()
+	if jwks == nil {
+		if c.deferredJWKS() {
+			// Not loaded yet is ready: the first token fetches the JWKS
+			return ""
+		}
+		var err error
+		if jwks, err = c.loadJWKS(); err != nil {
+			return "JWKS not initialized"
+		}
+	}
+	if keys, err := jwks.Storage().KeyReadAll(ctx); err != nil || len(keys) == 0 {
+		return "JWKS has no keys"
+	}
+	return ""
+}
+
+// secretFlags lists flags whose values are masked in diagnostics output
+var secretFlags = map[string]bool{
+	"gateway-secret":              true,
+	"admin-token":                 true,
+	"log-subject-salt":            true,
+	"dev-token":                   true,
+	"introspection-client-secret": true,
+}
+
+// serverStats holds process-wide request counters
+type serverStats struct {
+	startTime    time.Time
+	requests     atomic.Int64
+	authSuccess  atomic.Int64
+	authFailures atomic.Int64
+	// algNoneRejected counts tokens rejected for declaring alg=none
+	algNoneRejected atomic.Int64
+}
+
+var stats = &serverStats{startTime: time.Now()}
+
+// AdminMiddleware requires the admin bearer token for administrative endpoints
+func AdminMiddleware(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			logger.Warn("Rejected admin request", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			writeError(w, http.StatusForbidden, "invalid admin token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// effectiveFlags returns the current value of every flag with secrets masked
+func effectiveFlags() map[string]string {
+	values := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = "********"
+		}
+		values[f.Name] = value
+	})
+	return values
+}
+
+// HandleDiagnostics returns a JSON snapshot of the server state for support bundles
+func (c *OAuthConfig) HandleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	jwks := c.currentJWKS()
+	jwksStatus := map[string]any{
+		"url":         c.JwksURL,
+		"initialized": jwks != nil,
+	}
+	if jwks != nil {
+		keys, err := jwks.Storage().KeyReadAll(r.Context())
+		if err != nil {
+			jwksStatus["error"] = err.Error()
+		} else {
+			kids := make([]string, 0, len(keys))
+			for _, k := range keys {
+				kids = append(kids, k.Marshal().KID)
+			}
+			jwksStatus["kids"] = kids
+		}
+	}
+
+	diagnostics := map[string]any{
+		"version": versionInfo(),
+		"uptime":  time.Since(stats.startTime).Round(time.Second).String(),
+		"config":  effectiveFlags(),
+		"jwks":    jwksStatus,
+		"requests": map[string]any{
+			"total":             stats.requests.Load(),
+			"auth_success":      stats.authSuccess.Load(),
+			"auth_failures":     stats.authFailures.Load(),
+			"alg_none_rejected": stats.algNoneRejected.Load(),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diagnostics)
+}
+
+// HandleMaintenance reports maintenance mode on GET and changes it on POST.
+// POST accepts ?enabled=true|false; without it the current state is toggled.
+func HandleMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		enabled := !maintenanceMode.Load()
+		if v := r.URL.Query().Get("enabled"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid enabled value")
+				return
+			}
+			enabled = b
+		}
+		setMaintenanceMode(enabled)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"maintenance": maintenanceMode.Load()})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+// useFlags replaces the command line flags with a set defining the name/value pairs until the test ends
+func useFlags(t *testing.T, nameValues ...string) {
+	t.Helper()
+	saved := flag.CommandLine
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	t.Cleanup(func() { flag.CommandLine = saved })
+	for i := 0; i+1 < len(nameValues); i += 2 {
+		flag.String(nameValues[i], "", "")
+		if err := flag.Set(nameValues[i], nameValues[i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	useFlags(t, "admin-token", "s3cret", "introspection-client-secret", "", "jwks-url", "https://idp.example.com/certs")
+	c := newTestOAuthConfig(t, newTestKey(t))
+	handler := AdminMiddleware("s3cret", http.HandlerFunc(c.HandleDiagnostics))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/diagnostics", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status without the admin token = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/diagnostics", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var diagnostics map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &diagnostics); err != nil {
+		t.Fatalf("invalid diagnostics %q: %v", rec.Body.String(), err)
+	}
+	for _, key := range []string{"version", "uptime", "config", "jwks", "requests"} {
+		if _, ok := diagnostics[key]; !ok {
+			t.Errorf("diagnostics lack %q: %s", key, rec.Body.String())
+		}
+	}
+
+	var config map[string]string
+	json.Unmarshal(diagnostics["config"], &config)
+	if got := config["admin-token"]; got != "********" {
+		t.Errorf("admin-token = %q, want it masked", got)
+	}
+	// Unset secrets stay empty, so operators can tell they are not configured
+	if got := config["introspection-client-secret"]; got != "" {
+		t.Errorf("unset introspection-client-secret = %q, want empty", got)
+	}
+	if got := config["jwks-url"]; got != "https://idp.example.com/certs" {
+		t.Errorf("jwks-url = %q, want it unmasked", got)
+	}
+
+	var jwks struct {
+		Initialized bool     `json:"initialized"`
+		KIDs        []string `json:"kids"`
+	}
+	json.Unmarshal(diagnostics["jwks"], &jwks)
+	if !jwks.Initialized || !slices.Contains(jwks.KIDs, testKID) {
+		t.Errorf("jwks = %s, want the initialized key set with %s", diagnostics["jwks"], testKID)
+	}
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	t.Cleanup(func() { maintenanceMode.Store(false) })
+
+	// The routes are mounted as main does
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", HandleHealthz)
+	mux.HandleFunc("/readyz", c.HandleReadyz)
+	mux.Handle("/admin/maintenance", AdminMiddleware("s3cret", http.HandlerFunc(HandleMaintenance)))
+	mux.Handle("/", MaintenanceMiddleware(c.OAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))))
+	token := key.mint(t, validClaims())
+	serve := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	// assertStatus checks the status of each path, with the token for the MCP endpoint
+	assertStatus := func(state string, mcp, healthz, readyz int) {
+		t.Helper()
+		if rec := serve(http.MethodPost, "/", token); rec.Code != mcp {
+			t.Errorf("%s: MCP request status = %d, want %d", state, rec.Code, mcp)
+		}
+		if rec := serve(http.MethodGet, "/healthz", ""); rec.Code != healthz {
+			t.Errorf("%s: /healthz status = %d, want %d", state, rec.Code, healthz)
+		}
+		if rec := serve(http.MethodGet, "/readyz", ""); rec.Code != readyz {
+			t.Errorf("%s: /readyz status = %d, want %d", state, rec.Code, readyz)
+		}
+	}
+
+	assertStatus("before maintenance", http.StatusOK, http.StatusOK, http.StatusOK)
+
+	if rec := serve(http.MethodPost, "/admin/maintenance?enabled=true", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("toggle without the admin token: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := serve(http.MethodPost, "/admin/maintenance?enabled=true", "s3cret"); rec.Code != http.StatusOK || !maintenanceMode.Load() {
+		t.Fatalf("enabling maintenance: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	assertStatus("during maintenance", http.StatusServiceUnavailable, http.StatusOK, http.StatusServiceUnavailable)
+	if rec := serve(http.MethodPost, "/", token); rec.Header().Get("Retry-After") == "" {
+		t.Error("MCP request during maintenance lacks Retry-After")
+	}
+
+	// Without enabled, POST toggles the current state
+	if rec := serve(http.MethodPost, "/admin/maintenance", "s3cret"); rec.Code != http.StatusOK || maintenanceMode.Load() {
+		t.Fatalf("toggling maintenance off: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	assertStatus("after maintenance", http.StatusOK, http.StatusOK, http.StatusOK)
+}
+
+func TestVersion(t *testing.T) {
+	version := func() map[string]string {
+		rec := httptest.NewRecorder()
+		HandleVersion(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		var info map[string]string
+		if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+			t.Fatalf("invalid version %q: %v", rec.Body.String(), err)
+		}
+		return info
+	}
+
+	// Test binaries carry no ldflags or VCS stamp, so the build information falls back to unknown
+	info := version()
+	want := map[string]string{"name": serverName, "version": serverVersion, "go_version": runtime.Version(), "git_commit": "unknown", "build_date": "unknown"}
+	for key, value := range want {
+		if info[key] != value {
+			t.Errorf("%s = %q, want %q", key, info[key], value)
+		}
+	}
+	if _, err := time.ParseDuration(info["uptime"]); err != nil {
+		t.Errorf("uptime = %q, want a duration", info["uptime"])
+	}
+
+	savedCommit, savedDate := gitCommit, buildDate
+	t.Cleanup(func() { gitCommit, buildDate = savedCommit, savedDate })
+	gitCommit, buildDate = "abc1234", "2026-01-02T03:04:05Z"
+	if info := version(); info["git_commit"] != "abc1234" || info["build_date"] != "2026-01-02T03:04:05Z" {
+		t.Errorf("git_commit = %q, build_date = %q, want the values injected with ldflags", info["git_commit"], info["build_date"])
+	}
+
+	rec := httptest.NewRecorder()
+	HandleVersion(rec, httptest.NewRequest(http.MethodPost, "/version", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
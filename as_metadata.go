@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxASMetadataBytes caps the size of the authorization server metadata read from upstream
+const maxASMetadataBytes = 1 << 20
+
+// ASMetadataProxy re-serves the upstream authorization server metadata (RFC 8414) from this server,
+// for browser-based clients that cannot reach the authorization server directly because it sends no CORS headers
+type ASMetadataProxy struct {
+	issuer string
+	ttl    time.Duration
+	client *http.Client
+
+	mu        sync.Mutex
+	metadata  []byte
+	fetchedAt time.Time
+}
+
+// NewASMetadataProxy creates a proxy for the metadata of issuer, cached for ttl
+func NewASMetadataProxy(issuer string, ttl time.Duration) *ASMetadataProxy {
+	return &ASMetadataProxy{issuer: issuer, ttl: ttl, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// metadataURLs returns the metadata locations to try for the issuer, in the order the MCP authorization spec
+// recommends: RFC 8414 path insertion, then OpenID Connect Discovery with path insertion and path appending
+func (p *ASMetadataProxy) metadataURLs() []string {
+	u, err := url.Parse(p.issuer)
+	if err != nil {
+		return nil
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	base := u.Scheme + "://" + u.Host
+	urls := []string{base + "/.well-known/oauth-authorization-server" + path}
+	if path != "" {
+		urls = append(urls, base+"/.well-known/openid-configuration"+path)
+	}
+	return append(urls, base+path+"/.well-known/openid-configuration")
+}
+
+// fetch retrieves the metadata from the first location that returns valid JSON
+func (p *ASMetadataProxy) fetch(ctx context.Context) ([]byte, error) {
+	var errs []string
+	for _, u := range p.metadataURLs() {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create metadata request: %w", err)
+		}
+		req.Header.Set("Accept", "application/json")
+		resp, err := p.client.Do(req)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxASMetadataBytes))
+		resp.Body.Close()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", u, err))
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			errs = append(errs, fmt.Sprintf("%s: status %d", u, resp.StatusCode))
+			continue
+		}
+		if !json.Valid(body) {
+			errs = append(errs, fmt.Sprintf("%s: response is not JSON", u))
+			continue
+		}
+		return body, nil
+	}
+	return nil, fmt.Errorf("failed to fetch authorization server metadata: %s", strings.Join(errs, "; "))
+}
+
+// current returns the cached metadata, refreshing it once it is older than the TTL.
+// When a refresh fails, the previous metadata keeps being served and the refresh is retried after another TTL.
+func (p *ASMetadataProxy) current(ctx context.Context) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil && time.Since(p.fetchedAt) < p.ttl {
+		return p.metadata, nil
+	}
+	metadata, err := p.fetch(ctx)
+	if err != nil {
+		if p.metadata != nil {
+			logger.Warn("Serving stale authorization server metadata", "error", err)
+			p.fetchedAt = time.Now()
+			return p.metadata, nil
+		}
+		return nil, err
+	}
+	p.metadata, p.fetchedAt = metadata, time.Now()
+	return metadata, nil
+}
+
+// HandleASMetadata serves the cached authorization server metadata (no authorization required).
+// CORS headers are added by CORSMiddleware.
+func (p *ASMetadataProxy) HandleASMetadata(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	metadata, err := p.current(r.Context())
+	if err != nil {
+		logger.Error("Authorization server metadata unavailable", "error", err)
+		writeError(w, http.StatusBadGateway, "authorization server metadata unavailable")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(p.ttl.Seconds())))
+	w.Write(metadata)
+}
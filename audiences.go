@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// LoadAudiencesFile loads additional accepted audiences from AudiencesFile and swaps them in atomically
+func (c *OAuthConfig) LoadAudiencesFile() error {
+	// Stat before reading, so a change made while loading is still picked up by the watcher
+	var modTime time.Time
+	if fi, err := os.Stat(c.AudiencesFile); err == nil {
+		modTime = fi.ModTime()
+	}
+	data, err := os.ReadFile(c.AudiencesFile)
+	if err != nil {
+		return fmt.Errorf("failed to read audiences file: %w", err)
+	}
+
+	// One audience per line; blank lines and lines starting with # are ignored
+	var audiences []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		aud := strings.TrimSpace(scanner.Text())
+		if aud == "" || strings.HasPrefix(aud, "#") {
+			continue
+		}
+		u, err := url.Parse(aud)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid audience %q on line %d: must be an absolute URL", aud, line)
+		}
+		audiences = append(audiences, aud)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to parse audiences file: %w", err)
+	}
+
+	c.additionalAudiences.Store(&audiences)
+	c.audiencesModTime = modTime
+	logger.Info("Loaded additional audiences", "count", len(audiences), "file", c.AudiencesFile)
+	return nil
+}
+
+// WatchAudiencesFile polls AudiencesFile and reloads it when it differs from the last loaded version, until ctx is done.
+// A file that fails validation is logged and the previous audiences stay active.
+func (c *OAuthConfig) WatchAudiencesFile(ctx context.Context, interval time.Duration) {
+	lastMod := c.audiencesModTime
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fi, err := os.Stat(c.AudiencesFile)
+			if err != nil {
+				logger.Warn("Failed to stat audiences file", "file", c.AudiencesFile, "error", err)
+				continue
+			}
+			if fi.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = fi.ModTime()
+			if err := c.LoadAudiencesFile(); err != nil {
+				logger.Warn("Keeping previous audiences", "error", err)
+			}
+		}
+	}
+}
+
+// fileAudiences returns the audiences currently loaded from AudiencesFile
+func (c *OAuthConfig) fileAudiences() []string {
+	if audiences := c.additionalAudiences.Load(); audiences != nil {
+		return *audiences
+	}
+	return nil
+}
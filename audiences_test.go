@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeAudiences replaces the audiences file, moving its modification time forward so the watcher notices
+func writeAudiences(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAudiencesFileReload(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	c.AudiencesFile = filepath.Join(t.TempDir(), "audiences.txt")
+	now := time.Now()
+	writeAudiences(t, c.AudiencesFile, "# onboarded tenants\nhttps://tenant-a.example.com\n", now)
+	if err := c.LoadAudiencesFile(); err != nil {
+		t.Fatalf("LoadAudiencesFile: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.WatchAudiencesFile(ctx, 10*time.Millisecond)
+
+	// accepted reports whether a token for the audience is accepted
+	accepted := func(aud string) bool {
+		claims := validClaims()
+		claims["aud"] = aud
+		_, reached := authorize(c, key.mint(t, claims))
+		return reached
+	}
+	// eventually waits for the audience to be accepted or not after a reload
+	eventually := func(aud string, want bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for accepted(aud) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("audience %s accepted = %v after the reload, want %v", aud, !want, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if !accepted("https://tenant-a.example.com") {
+		t.Fatal("audience from the file rejected")
+	}
+	if accepted("https://tenant-b.example.com") {
+		t.Fatal("audience not yet in the file accepted")
+	}
+
+	writeAudiences(t, c.AudiencesFile, "https://tenant-a.example.com\nhttps://tenant-b.example.com\n", now.Add(time.Second))
+	eventually("https://tenant-b.example.com", true)
+
+	// An invalid file is not swapped in, so the previous audiences stay accepted
+	writeAudiences(t, c.AudiencesFile, "tenant-c\n", now.Add(2*time.Second))
+	time.Sleep(100 * time.Millisecond)
+	if !accepted("https://tenant-b.example.com") {
+		t.Error("previous audiences dropped after an invalid file")
+	}
+
+	writeAudiences(t, c.AudiencesFile, "https://tenant-a.example.com\n", now.Add(3*time.Second))
+	eventually("https://tenant-b.example.com", false)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// LoadConfigFile sets the flags listed in a YAML (.yaml, .yml) or JSON (.json) config file.
+// Keys are flag names without the leading dash; lists are accepted for comma-separated flags and objects are passed as JSON.
+// Flags given on the command line take precedence over the file; every invalid entry is reported.
+func LoadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var settings map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		err = json.Unmarshal(data, &settings)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &settings)
+	default:
+		return fmt.Errorf("unsupported config file extension %q: use .yaml, .yml or .json", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(settings)) {
+		if flag.Lookup(name) == nil || name == "config" {
+			errs = append(errs, fmt.Errorf("%s: unknown setting", name))
+			continue
+		}
+		value, err := configValue(settings[name])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		if explicit[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value %q: %w", name, value, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// configValue converts a config file value to its flag form
+func configValue(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			if _, isList := e.([]any); isList {
+				return "", errors.New("nested lists are not supported")
+			}
+			value, err := configValue(e)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, value)
+		}
+		return strings.Join(values, ","), nil
+	case map[string]any, map[any]any:
+		// Objects (e.g. an inline JWKS) are passed to the flag as JSON
+		data, err := json.Marshal(jsonCompatible(v))
+		if err != nil {
+			return "", fmt.Errorf("unsupported object: %w", err)
+		}
+		return string(data), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v: must be a string, number, boolean, list or object", v)
+	}
+}
+
+// jsonCompatible converts the map[any]any values produced by the YAML decoder into map[string]any for JSON encoding
+func jsonCompatible(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonCompatible(e)
+		}
+		return m
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[k] = jsonCompatible(e)
+		}
+		return m
+	case []any:
+		l := make([]any, len(v))
+		for i, e := range v {
+			l[i] = jsonCompatible(e)
+		}
+		return l
+	default:
+		return v
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// configFlags replaces the command line flags with a representative set parsed from args until the test ends
+func configFlags(t *testing.T, args ...string) (resourceURL, scopes *string, rateLimit *int, debug *bool) {
+	t.Helper()
+	saved := flag.CommandLine
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	t.Cleanup(func() { flag.CommandLine = saved })
+	flag.String("config", "", "")
+	resourceURL = flag.String("resource-url", "http://localhost:8080", "")
+	scopes = flag.String("required-scopes", "", "")
+	rateLimit = flag.Int("rate-limit", 10, "")
+	debug = flag.Bool("debug", false, "")
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return resourceURL, scopes, rateLimit, debug
+}
+
+// writeConfig writes a config file with the name and content to a temporary directory
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFilePrecedence(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": "resource-url: https://mcp.example.com\nrequired-scopes:\n  - mcp:read\n  - mcp:tools\ndebug: true\n",
+		"config.json": `{"resource-url": "https://mcp.example.com", "required-scopes": ["mcp:read", "mcp:tools"], "debug": true}`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			resourceURL, scopes, rateLimit, debug := configFlags(t, "-resource-url", "https://flag.example.com")
+			if err := LoadConfigFile(writeConfig(t, name, content)); err != nil {
+				t.Fatalf("LoadConfigFile: %v", err)
+			}
+			// The command line overrides the file, which overrides the defaults
+			if *resourceURL != "https://flag.example.com" {
+				t.Errorf("resource-url = %q, want the command line value", *resourceURL)
+			}
+			if *scopes != "mcp:read,mcp:tools" {
+				t.Errorf("required-scopes = %q, want the file's list joined", *scopes)
+			}
+			if !*debug {
+				t.Error("debug = false, want the file value")
+			}
+			if *rateLimit != 10 {
+				t.Errorf("rate-limit = %d, want the default", *rateLimit)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileValidation(t *testing.T) {
+	configFlags(t)
+	err := LoadConfigFile(writeConfig(t, "config.yaml", "rate-limit: fast\nrequired-scopes: [[a, b]]\nresouce-url: https://mcp.example.com\nconfig: other.yaml\n"))
+	if err == nil {
+		t.Fatal("invalid config file accepted")
+	}
+	// Every offending field is reported by name
+	for _, want := range []string{`rate-limit: invalid value "fast"`, "required-scopes: nested lists are not supported", "resouce-url: unknown setting", "config: unknown setting"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error lacks %q:\n%v", want, err)
+		}
+	}
+
+	if err := LoadConfigFile(writeConfig(t, "config.toml", "debug = true\n")); err == nil || !strings.Contains(err.Error(), "unsupported config file extension") {
+		t.Errorf("error for a .toml file = %v, want an unsupported extension", err)
+	}
+	if err := LoadConfigFile(writeConfig(t, "config.json", "{")); err == nil || !strings.Contains(err.Error(), "failed to parse config file") {
+		t.Errorf("error for malformed JSON = %v, want a parse error", err)
+	}
+	if err := LoadConfigFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil || !strings.Contains(err.Error(), "failed to read config file") {
+		t.Errorf("error for a missing file = %v, want a read error", err)
+	}
+}
+
+func TestConfigFileInlineJWKS(t *testing.T) {
+	key := newTestKey(t)
+	files := map[string]string{
+		// A JSON object is also a YAML flow mapping
+		"config.yaml": "jwks: " + key.jwksJSON() + "\n",
+		"config.json": `{"jwks": ` + key.jwksJSON() + `}`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			configFlags(t)
+			inlineJWKS := flag.String("jwks", "", "")
+			if err := LoadConfigFile(writeConfig(t, name, content)); err != nil {
+				t.Fatalf("LoadConfigFile: %v", err)
+			}
+			// No JWKS URL is configured, so the token can only be verified with the inline keys
+			c := &OAuthConfig{AuthzServerURL: testIssuer, InlineJWKS: *inlineJWKS, ResourceURL: testResource}
+			if err := c.InitJWKS(); err != nil {
+				t.Fatalf("InitJWKS: %v", err)
+			}
+			defer c.Close()
+			if rec, reached := authorize(c, key.mint(t, validClaims())); !reached {
+				t.Errorf("token signed by the inline key rejected with status %d: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+
+	// The inline JWKS is validated on load
+	for _, jwks := range []string{`{"keys":[]}`, `{"keys":`} {
+		c := &OAuthConfig{AuthzServerURL: testIssuer, InlineJWKS: jwks, ResourceURL: testResource}
+		if err := c.InitJWKS(); err == nil || !strings.Contains(err.Error(), "invalid inline JWKS") {
+			t.Errorf("InitJWKS with %s: error = %v, want an invalid inline JWKS", jwks, err)
+		}
+	}
+}
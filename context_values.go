@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// staticContextKey is the context key for the static values configured with -context-values
+type staticContextKey struct{}
+
+// StaticContextMiddleware adds static values (e.g. tenant id, environment name) to the context of every MCP request,
+// so tool handlers can read them with StaticContextValue instead of depending on globals
+func StaticContextMiddleware(values map[string]string) mcp.Middleware {
+	return func(next mcp.MethodHandler) mcp.MethodHandler {
+		return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+			return next(context.WithValue(ctx, staticContextKey{}, values), method, req)
+		}
+	}
+}
+
+// StaticContextValue returns the static context value for key
+func StaticContextValue(ctx context.Context, key string) (string, bool) {
+	values, _ := ctx.Value(staticContextKey{}).(map[string]string)
+	value, ok := values[key]
+	return value, ok
+}
+
+// StaticContextValues returns all static context values; the map must not be modified
+func StaticContextValues(ctx context.Context) map[string]string {
+	values, _ := ctx.Value(staticContextKey{}).(map[string]string)
+	return values
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// introspectionClient is used for calls to the token introspection endpoint; each attempt is bounded by IntrospectionTimeout
+var introspectionClient = &http.Client{}
+
+// defaultIntrospectionTimeout bounds an introspection attempt when IntrospectionTimeout is zero
+const defaultIntrospectionTimeout = 10 * time.Second
+
+// errIntrospectionCircuitOpen is returned without calling the endpoint while the circuit breaker is open
+type errIntrospectionCircuitOpen struct {
+	retryAfter time.Duration
+}
+
+func (e *errIntrospectionCircuitOpen) Error() string {
+	return fmt.Sprintf("introspection endpoint unavailable, retrying after %v", e.retryAfter.Round(time.Second))
+}
+
+// introspectionBreaker stops calling the introspection endpoint for a cooldown after consecutive failures,
+// so requests fail fast instead of each waiting for an endpoint that is down.
+// After the cooldown one request is let through: success closes the circuit, failure opens it again.
+type introspectionBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// allow reports how long the circuit stays open, or 0 when the endpoint may be called
+func (b *introspectionBreaker) allow(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now.Before(b.openUntil) {
+		return b.openUntil.Sub(now)
+	}
+	return 0
+}
+
+// record counts a call's outcome, opening the circuit for cooldown once threshold calls in a row have failed
+func (b *introspectionBreaker) record(now time.Time, failed bool, threshold int, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= threshold {
+		if !now.Before(b.openUntil) {
+			logger.Warn("Introspection circuit opened", "consecutive_failures", b.failures, "cooldown", cooldown)
+		}
+		b.openUntil = now.Add(cooldown)
+	}
+}
+
+// isJWT reports whether the token is structurally a JWT; anything else is treated as an opaque token
+func isJWT(tokenString string) bool {
+	_, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
+	return !errors.Is(err, jwt.ErrTokenMalformed)
+}
+
+// introspect validates an opaque token with the RFC 7662 introspection endpoint and returns the response as claims.
+// Failed attempts are retried up to IntrospectionRetries times, and consecutive failures trip the circuit breaker.
+func (c *OAuthConfig) introspect(ctx context.Context, tokenString string) (jwt.MapClaims, error) {
+	breakerEnabled := c.IntrospectionBreakerThreshold > 0
+	if breakerEnabled {
+		if retryAfter := c.introspectionBreaker.allow(time.Now()); retryAfter > 0 {
+			return nil, &errIntrospectionCircuitOpen{retryAfter: retryAfter}
+		}
+	}
+
+	var claims jwt.MapClaims
+	var err error
+	for attempt := 0; attempt <= c.IntrospectionRetries; attempt++ {
+		var retryable bool
+		claims, retryable, err = c.introspectOnce(ctx, tokenString)
+		if err == nil || !retryable || ctx.Err() != nil {
+			break
+		}
+		if attempt < c.IntrospectionRetries {
+			logger.Debug("Retrying token introspection", "attempt", attempt+1, "error", err)
+		}
+	}
+	if breakerEnabled {
+		// A caller that went away says nothing about the endpoint
+		if ctx.Err() == nil {
+			c.introspectionBreaker.record(time.Now(), err != nil, c.IntrospectionBreakerThreshold, c.introspectionBreakerCooldown())
+		}
+	}
+	return claims, err
+}
+
+// introspectionBreakerCooldown returns how long the circuit stays open (30 seconds when zero)
+func (c *OAuthConfig) introspectionBreakerCooldown() time.Duration {
+	if c.IntrospectionBreakerCooldown <= 0 {
+		return 30 * time.Second
+	}
+	return c.IntrospectionBreakerCooldown
+}
+
+// introspectOnce makes one introspection call, reporting whether a failure is worth retrying
+// (transport errors, timeouts and 5xx responses)
+func (c *OAuthConfig) introspectOnce(ctx context.Context, tokenString string) (jwt.MapClaims, bool, error) {
+	timeout := c.IntrospectionTimeout
+	if timeout <= 0 {
+		timeout = defaultIntrospectionTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	form := url.Values{
+		"token":           {tokenString},
+		"token_type_hint": {"access_token"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.IntrospectionURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create introspection request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.IntrospectionClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(c.IntrospectionClientID), url.QueryEscape(c.IntrospectionClientSecret))
+	}
+
+	resp, err := introspectionClient.Do(req)
+	if err != nil {
+		return nil, true, fmt.Errorf("introspection request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode >= 500, fmt.Errorf("introspection endpoint returned status %d", resp.StatusCode)
+	}
+
+	var claims jwt.MapClaims
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, false, fmt.Errorf("failed to decode introspection response: %w", err)
+	}
+	// iss is optional in introspection responses; the configured endpoint speaks for the authorization server
+	if _, ok := claims["iss"]; !ok {
+		claims["iss"] = c.AuthzServerURL
+	}
+	return claims, false, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newIntrospectionServer serves an introspection endpoint that fails with 503 while failing is set
+func newIntrospectionServer(t *testing.T, failing *atomic.Bool, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"active":true,"sub":"alice","aud":"http://localhost:8000","scope":"mcp:tools","exp":` + strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10) + `}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestIntrospectionBreakerTripsAndRecovers(t *testing.T) {
+	var failing atomic.Bool
+	var calls atomic.Int32
+	failing.Store(true)
+	srv := newIntrospectionServer(t, &failing, &calls)
+	c := &OAuthConfig{IntrospectionURL: srv.URL, IntrospectionBreakerThreshold: 2, IntrospectionBreakerCooldown: 200 * time.Millisecond}
+
+	for i := range 2 {
+		if _, err := c.introspect(context.Background(), "opaque"); err == nil {
+			t.Fatalf("introspection %d against a failing endpoint succeeded", i+1)
+		}
+	}
+
+	// The circuit is open: the endpoint is not called
+	var circuitOpen *errIntrospectionCircuitOpen
+	if _, err := c.introspect(context.Background(), "opaque"); !errors.As(err, &circuitOpen) {
+		t.Fatalf("introspection with the circuit open returned %v, want errIntrospectionCircuitOpen", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("endpoint called %d times, want 2", n)
+	}
+
+	// After the cooldown the endpoint is tried again and success closes the circuit
+	failing.Store(false)
+	time.Sleep(250 * time.Millisecond)
+	claims, err := c.introspect(context.Background(), "opaque")
+	if err != nil {
+		t.Fatalf("introspection after the cooldown: %v", err)
+	}
+	if claims["sub"] != "alice" {
+		t.Errorf("sub = %v, want alice", claims["sub"])
+	}
+	if _, err := c.introspect(context.Background(), "opaque"); err != nil {
+		t.Errorf("introspection after recovery: %v", err)
+	}
+}
+
+func TestIntrospectionRetries(t *testing.T) {
+	var failing atomic.Bool
+	var calls atomic.Int32
+	failing.Store(true)
+	srv := newIntrospectionServer(t, &failing, &calls)
+	c := &OAuthConfig{IntrospectionURL: srv.URL, IntrospectionRetries: 2}
+
+	if _, err := c.introspect(context.Background(), "opaque"); err == nil {
+		t.Fatal("introspection against a failing endpoint succeeded")
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("endpoint called %d times, want 3 (1 attempt and 2 retries)", n)
+	}
+}
+
+func TestIntrospectionTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	c := &OAuthConfig{IntrospectionURL: srv.URL, IntrospectionTimeout: 50 * time.Millisecond}
+
+	start := time.Now()
+	if _, err := c.introspect(context.Background(), "opaque"); err == nil {
+		t.Fatal("introspection against a hanging endpoint succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("introspection took %v, want it bounded by the timeout", elapsed)
+	}
+}
+
+func TestOAuthMiddlewareIntrospectionCircuitOpen(t *testing.T) {
+	var failing atomic.Bool
+	var calls atomic.Int32
+	failing.Store(true)
+	srv := newIntrospectionServer(t, &failing, &calls)
+
+	for _, tt := range []struct {
+		failClosed bool
+		want       int
+	}{
+		{false, http.StatusServiceUnavailable},
+		{true, http.StatusUnauthorized},
+	} {
+		c := &OAuthConfig{IntrospectionURL: srv.URL, IntrospectionBreakerThreshold: 1, IntrospectionBreakerCooldown: time.Minute, IntrospectionBreakerFailClosed: tt.failClosed}
+		handler := c.OAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		serve := func() *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.Header.Set("Authorization", "Bearer opaque")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec
+		}
+
+		if rec := serve(); rec.Code != http.StatusUnauthorized {
+			t.Errorf("failClosed=%v: failed introspection status = %d, want %d", tt.failClosed, rec.Code, http.StatusUnauthorized)
+		}
+		rec := serve()
+		if rec.Code != tt.want {
+			t.Errorf("failClosed=%v: status with the circuit open = %d, want %d", tt.failClosed, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("failClosed=%v: no Retry-After while the circuit is open", tt.failClosed)
+		}
+	}
+}
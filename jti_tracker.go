@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// JTITracker counts the distinct token IDs (jti) each subject presents within a sliding window.
+// An implausible number of distinct tokens for one subject suggests credential sharing or token farming.
+type JTITracker struct {
+	max       int
+	window    time.Duration
+	mu        sync.Mutex
+	seen      map[string]map[string]time.Time // subject -> jti -> first seen
+	lastSweep time.Time
+}
+
+// NewJTITracker creates a tracker allowing up to max distinct tokens per subject within window
+func NewJTITracker(max int, window time.Duration) *JTITracker {
+	return &JTITracker{max: max, window: window, seen: make(map[string]map[string]time.Time), lastSweep: time.Now()}
+}
+
+// Observe records the token ID for the subject and reports whether the subject is still within the limit
+func (t *JTITracker) Observe(sub, jti string) bool {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Drop subjects that have not been seen within the window so idle subjects do not accumulate
+	if now.Sub(t.lastSweep) > t.window {
+		for s, tokens := range t.seen {
+			t.prune(tokens, now)
+			if len(tokens) == 0 {
+				delete(t.seen, s)
+			}
+		}
+		t.lastSweep = now
+	}
+
+	tokens := t.seen[sub]
+	if tokens == nil {
+		tokens = make(map[string]time.Time)
+		t.seen[sub] = tokens
+	}
+	t.prune(tokens, now)
+	if _, ok := tokens[jti]; !ok {
+		tokens[jti] = now
+	}
+	return len(tokens) <= t.max
+}
+
+// prune removes tokens first seen before the window
+func (t *JTITracker) prune(tokens map[string]time.Time, now time.Time) {
+	for jti, first := range tokens {
+		if now.Sub(first) > t.window {
+			delete(tokens, jti)
+		}
+	}
+}
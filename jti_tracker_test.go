@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJTITrackerRejectsTokenFarming(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	c.JTITracker = NewJTITracker(3, time.Hour)
+	var securityLog bytes.Buffer
+	c.SecurityLog = NewSecurityLogger(&securityLog)
+
+	// mint returns a token for sub with the token ID jti
+	mint := func(sub, jti string) string {
+		claims := validClaims()
+		claims["sub"], claims["jti"] = sub, jti
+		return key.mint(t, claims)
+	}
+	first := mint("alice", "jti-1")
+	for i := 1; i <= 3; i++ {
+		if rec, reached := authorize(c, mint("alice", fmt.Sprintf("jti-%d", i))); !reached {
+			t.Fatalf("distinct token %d within the threshold rejected with status %d", i, rec.Code)
+		}
+	}
+	// Presenting the same token again is not another distinct token
+	if rec, reached := authorize(c, first); !reached {
+		t.Fatalf("reused token rejected with status %d", rec.Code)
+	}
+
+	securityLog.Reset()
+	rec, reached := authorize(c, mint("alice", "jti-4"))
+	if reached {
+		t.Fatal("token beyond the threshold accepted")
+	}
+	assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+	if !strings.Contains(securityLog.String(), `"decision":"deny","reason":"too many distinct tokens for subject","subject":"alice"`) {
+		t.Errorf("security log lacks the flagged subject:\n%s", securityLog.String())
+	}
+
+	// Other subjects have their own count
+	if rec, reached := authorize(c, mint("bob", "jti-5")); !reached {
+		t.Errorf("another subject rejected with status %d", rec.Code)
+	}
+}
+
+func TestJTITrackerWindow(t *testing.T) {
+	tracker := NewJTITracker(1, 50*time.Millisecond)
+	if !tracker.Observe("alice", "jti-1") {
+		t.Fatal("first token rejected")
+	}
+	if tracker.Observe("alice", "jti-2") {
+		t.Fatal("second distinct token within the window accepted")
+	}
+	// Tokens seen before the window no longer count
+	time.Sleep(100 * time.Millisecond)
+	if !tracker.Observe("alice", "jti-3") {
+		t.Error("token after the window rejected")
+	}
+}
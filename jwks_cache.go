@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MicahParks/jwkset"
+	"github.com/MicahParks/keyfunc/v3"
+	"golang.org/x/time/rate"
+)
+
+// jwksCacheSyncInterval is how often fetched keys are compared with the cache file
+const jwksCacheSyncInterval = 30 * time.Second
+
+// jwksCache is the on-disk format of JwksCacheFile
+type jwksCache struct {
+	FetchedAt time.Time       `json:"fetched_at"`
+	JWKS      json.RawMessage `json:"jwks"`
+}
+
+// newCachedJWKS creates a JWKS client that starts from the cache file when the JWKS cannot be fetched at startup.
+// Fetched keys take priority; cached keys are dropped as soon as a fetch succeeds, and every new key set is written back to the cache.
+func (c *OAuthConfig) newCachedJWKS(ctx context.Context) (keyfunc.Keyfunc, error) {
+	refreshInterval := c.JwksRefreshInterval
+	if refreshInterval <= 0 {
+		refreshInterval = time.Hour
+	}
+	remote, err := jwkset.NewStorageFromHTTP(c.JwksURL, jwkset.HTTPClientStorageOptions{
+		Client:                    c.jwksClient(),
+		Ctx:                       ctx,
+		NoErrorReturnFirstHTTPReq: true,
+		RefreshErrorHandler:       jwksRefreshErrorHandler(c.JwksURL),
+		RefreshInterval:           refreshInterval,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWKS storage: %w", err)
+	}
+
+	cached := jwkset.NewMemoryStorage()
+	if keys, _ := remote.KeyReadAll(ctx); len(keys) == 0 {
+		if err := c.loadJWKSCache(ctx, cached); err != nil {
+			logger.Error("JWKS unavailable at startup and no usable cache", "error", err)
+		}
+	}
+
+	storage, err := jwkset.NewHTTPClient(jwkset.HTTPClientOptions{
+		Given:             cached,
+		HTTPURLs:          map[string]jwkset.Storage{c.JwksURL: remote},
+		PrioritizeHTTP:    true,
+		RateLimitWaitMax:  time.Minute,
+		RefreshUnknownKID: rate.NewLimiter(rate.Every(c.unknownKIDRefreshInterval()), 1),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWKS client: %w", err)
+	}
+	jwks, err := keyfunc.New(keyfunc.Options{Ctx: ctx, Storage: storage})
+	if err != nil {
+		return nil, err
+	}
+
+	go c.syncJWKSCache(ctx, remote, cached)
+	return jwks, nil
+}
+
+// loadJWKSCache loads the cached keys into storage if the cache is fresh enough
+func (c *OAuthConfig) loadJWKSCache(ctx context.Context, storage jwkset.Storage) error {
+	data, err := os.ReadFile(c.JwksCacheFile)
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS cache: %w", err)
+	}
+	var cache jwksCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return fmt.Errorf("failed to parse JWKS cache: %w", err)
+	}
+	if age := time.Since(cache.FetchedAt); age > c.JwksCacheMaxAge {
+		return fmt.Errorf("JWKS cache is stale (fetched %v ago, max age %v)", age.Round(time.Second), c.JwksCacheMaxAge)
+	}
+
+	var set jwkset.JWKSMarshal
+	if err := json.Unmarshal(cache.JWKS, &set); err != nil {
+		return fmt.Errorf("failed to parse cached JWKS: %w", err)
+	}
+	for _, marshal := range set.Keys {
+		jwk, err := jwkset.NewJWKFromMarshal(marshal, jwkset.JWKMarshalOptions{}, jwkset.JWKValidateOptions{})
+		if err != nil {
+			return fmt.Errorf("invalid key %q in JWKS cache: %w", marshal.KID, err)
+		}
+		if err := storage.KeyWrite(ctx, jwk); err != nil {
+			return err
+		}
+	}
+	c.jwksFetches.record(c.JwksURL, cache.FetchedAt)
+	logger.Warn("JWKS unavailable at startup; using cached keys", "keys", len(set.Keys), "fetched_at", cache.FetchedAt.Format(time.RFC3339))
+	return nil
+}
+
+// syncJWKSCache writes fetched keys to the cache file and drops the cached keys once the JWKS has been fetched, until ctx is done
+func (c *OAuthConfig) syncJWKSCache(ctx context.Context, remote, cached jwkset.Storage) {
+	var saved string
+	ticker := time.NewTicker(jwksCacheSyncInterval)
+	defer ticker.Stop()
+	for {
+		if keys, err := remote.KeyReadAll(ctx); err == nil && len(keys) > 0 {
+			// Cached keys may have been rotated out, so they must not outlive a successful fetch
+			if stale, _ := cached.KeyReadAll(ctx); len(stale) > 0 {
+				cached.KeyReplaceAll(ctx, nil)
+				logger.Info("JWKS fetched; replaced cached keys")
+			}
+			if raw, err := remote.JSONPublic(ctx); err == nil && string(raw) != saved {
+				if err := c.saveJWKSCache(raw); err != nil {
+					logger.Warn("Failed to write JWKS cache", "error", err)
+				} else {
+					saved = string(raw)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// saveJWKSCache atomically replaces the cache file with the given key set
+func (c *OAuthConfig) saveJWKSCache(raw json.RawMessage) error {
+	data, err := json.Marshal(jwksCache{FetchedAt: time.Now().UTC(), JWKS: raw})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.JwksCacheFile), ".jwks-cache-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.JwksCacheFile)
+}
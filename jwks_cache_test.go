@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newDownIdPConfig returns a config whose JWKS endpoint is down and that starts from a cache file
+// holding key's JWKS, fetched age ago
+func newDownIdPConfig(t *testing.T, key *testKey, age time.Duration) *OAuthConfig {
+	t.Helper()
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(jwksServer.Close)
+
+	data, err := json.Marshal(jwksCache{FetchedAt: time.Now().Add(-age), JWKS: json.RawMessage(key.jwksJSON())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheFile := filepath.Join(t.TempDir(), "jwks-cache.json")
+	if err := os.WriteFile(cacheFile, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &OAuthConfig{
+		AuthzServerURL:  testIssuer,
+		JwksURL:         jwksServer.URL,
+		ResourceURL:     testResource,
+		RequiredScopes:  []string{"mcp:tools"},
+		JwksCacheFile:   cacheFile,
+		JwksCacheMaxAge: time.Hour,
+	}
+	if err := c.InitJWKS(); err != nil {
+		t.Fatalf("InitJWKS: %v", err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// readyzStatus returns the /readyz status code for c
+func readyzStatus(c *OAuthConfig) int {
+	rec := httptest.NewRecorder()
+	c.HandleReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	return rec.Code
+}
+
+func TestJWKSCacheUsedWhileIdPDown(t *testing.T) {
+	key := newTestKey(t)
+	c := newDownIdPConfig(t, key, time.Minute)
+
+	if code := readyzStatus(c); code != http.StatusOK {
+		t.Errorf("/readyz with a fresh JWKS cache = %d, want %d", code, http.StatusOK)
+	}
+	if rec, reached := authorize(c, key.mint(t, validClaims())); !reached {
+		t.Errorf("token signed by a cached key rejected with status %d", rec.Code)
+	}
+}
+
+func TestJWKSCacheRejectsStaleFile(t *testing.T) {
+	key := newTestKey(t)
+	c := newDownIdPConfig(t, key, 2*time.Hour)
+
+	if code := readyzStatus(c); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz with a stale JWKS cache = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	rec, reached := authorize(c, key.mint(t, validClaims()))
+	if reached {
+		t.Fatal("token accepted with keys from a stale JWKS cache")
+	}
+	assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+}
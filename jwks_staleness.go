@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// jwksFetchRecorder is an HTTP transport recording when each JWKS URL last answered successfully
+type jwksFetchRecorder struct {
+	fetched sync.Map // JWKS URL -> time.Time
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *jwksFetchRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusOK {
+		t.record(req.URL.String(), time.Now())
+	}
+	return resp, err
+}
+
+// record notes that the keys of the JWKS at u are current as of at
+func (t *jwksFetchRecorder) record(u string, at time.Time) {
+	t.fetched.Store(u, at)
+}
+
+// lastFetch returns when the JWKS at u was last fetched, or the zero time if it never was
+func (t *jwksFetchRecorder) lastFetch(u string) time.Time {
+	at, _ := t.fetched.Load(u)
+	fetchedAt, _ := at.(time.Time)
+	return fetchedAt
+}
+
+// jwksClient returns the HTTP client used for JWKS fetches
+func (c *OAuthConfig) jwksClient() *http.Client {
+	return &http.Client{Transport: &c.jwksFetches}
+}
+
+// jwksStale reports whether the keys for tokens of issuer iss have not been refreshed within JwksMaxStaleness.
+// Refresh failures keep the last-good keys; this bounds how long they are trusted.
+func (c *OAuthConfig) jwksStale(iss string) (time.Duration, bool) {
+	if c.JwksMaxStaleness <= 0 {
+		return 0, false
+	}
+	u, ok := c.IssuerJwksURLs[iss]
+	if !ok {
+		u = c.JwksURL
+	}
+	fetchedAt := c.jwksFetches.lastFetch(u)
+	if fetchedAt.IsZero() {
+		// Never fetched: there are no keys to trust, so verification fails on its own
+		return 0, false
+	}
+	age := time.Since(fetchedAt)
+	return age, age > c.JwksMaxStaleness
+}
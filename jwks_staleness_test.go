@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJWKSRefreshFailureKeepsCachedKeys(t *testing.T) {
+	key := newTestKey(t)
+	var down atomic.Bool
+	var failedRefreshes atomic.Int32
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			failedRefreshes.Add(1)
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		key.serveJWKS(w, r)
+	}))
+	defer jwksServer.Close()
+	c := &OAuthConfig{AuthzServerURL: testIssuer, JwksURL: jwksServer.URL, ResourceURL: testResource, JwksRefreshInterval: 20 * time.Millisecond}
+	if err := c.InitJWKS(); err != nil {
+		t.Fatalf("InitJWKS: %v", err)
+	}
+	defer c.Close()
+
+	// The network goes away; wait for background refreshes to fail
+	down.Store(true)
+	deadline := time.Now().Add(2 * time.Second)
+	for failedRefreshes.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("no background JWKS refresh attempted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	token := key.mint(t, validClaims())
+	if rec, reached := authorize(c, token); !reached {
+		t.Fatalf("token rejected with cached keys after a failed refresh (status %d)", rec.Code)
+	}
+
+	// The staleness limit still bounds how long the cached keys are trusted; two refresh intervals have passed
+	c.JwksMaxStaleness = 10 * time.Millisecond
+	rec, reached := authorize(c, token)
+	if reached {
+		t.Fatal("token accepted with keys older than the staleness limit")
+	}
+	assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+}
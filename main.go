@@ -2,10 +2,28 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/modelcontextprotocol/go-sdk/auth"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 )
 
@@ -14,37 +32,248 @@ type EchoArgs struct {
 }
 
 func Echo(ctx context.Context, req *mcp.CallToolRequest, args *EchoArgs) (*mcp.CallToolResult, any, error) {
+	text := "Echo: " + args.Message
+	if sub := callerSubject(req); sub != "" {
+		text = fmt.Sprintf("Echo (%s): %s", sub, args.Message)
+	}
 	return &mcp.CallToolResult{
 		Content: []mcp.Content{
-			&mcp.TextContent{Text: "Echo: " + args.Message},
+			&mcp.TextContent{Text: text},
 		},
 	}, nil, nil
 }
 
-func main() {
-	// Parse command line flags
-	authzServerURL := flag.String("authz-server-url", "http://localhost/realms/demo", "Authorization Server URL")
-	jwksURL := flag.String("jwks-url", "http://localhost/realms/demo/protocol/openid-connect/certs", "JWKS URL")
-	resourceURL := flag.String("resource-url", "http://localhost:8000", "Resource URL for this server")
-	flag.Parse()
+// callerSubject returns the authenticated caller's subject, or "" when unknown
+func callerSubject(req *mcp.CallToolRequest) string {
+	if req.Extra == nil || req.Extra.TokenInfo == nil {
+		return ""
+	}
+	sub, _ := req.Extra.TokenInfo.Extra["sub"].(string)
+	return sub
+}
 
-	// Initialize OAuth config
-	oauthConfig := &OAuthConfig{
-		AuthzServerURL: *authzServerURL,
-		JwksURL:        *jwksURL,
-		ResourceURL:    *resourceURL,
+type Base64Args struct {
+	Mode string `json:"mode"`
+	Data string `json:"data"`
+}
+
+func Base64(ctx context.Context, req *mcp.CallToolRequest, args *Base64Args) (*mcp.CallToolResult, any, error) {
+	switch args.Mode {
+	case "encode":
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: base64.StdEncoding.EncodeToString([]byte(args.Data))},
+			},
+		}, nil, nil
+	case "decode":
+		decoded, err := base64.StdEncoding.DecodeString(args.Data)
+		if err != nil {
+			return &mcp.CallToolResult{
+				IsError: true,
+				Content: []mcp.Content{
+					&mcp.TextContent{Text: "Invalid base64 input: " + err.Error()},
+				},
+			}, nil, nil
+		}
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: string(decoded)},
+			},
+		}, nil, nil
+	default:
+		return &mcp.CallToolResult{
+			IsError: true,
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: "Unknown mode: " + args.Mode},
+			},
+		}, nil, nil
 	}
+}
 
-	if err := oauthConfig.InitJWKS(); err != nil {
-		log.Fatalf("Failed to initialize JWKS: %v", err)
+// whoamiClaimsScope is an optional scope that lets whoami include the full token claims
+const whoamiClaimsScope = "mcp:whoami:claims"
+
+type WhoamiArgs struct {
+	Format string `json:"format,omitempty"`
+}
+
+func Whoami(ctx context.Context, req *mcp.CallToolRequest, args *WhoamiArgs) (*mcp.CallToolResult, any, error) {
+	// There is no token over stdio
+	if req.Extra == nil || req.Extra.TokenInfo == nil {
+		return &mcp.CallToolResult{
+			IsError: true,
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: "No authenticated caller"},
+			},
+		}, nil, nil
 	}
 
+	tokenInfo := req.Extra.TokenInfo
+	sub, _ := tokenInfo.Extra["sub"].(string)
+	// Fine-grained decision inside the tool based on an optional scope
+	includeClaims := slices.Contains(tokenInfo.Scopes, whoamiClaimsScope)
+
+	switch args.Format {
+	case "", "text":
+		text := fmt.Sprintf("Subject: %s\nScopes: %s", sub, strings.Join(tokenInfo.Scopes, " "))
+		if values := StaticContextValues(ctx); len(values) > 0 {
+			valuesJSON, _ := json.Marshal(values)
+			text += "\nContext: " + string(valuesJSON)
+		}
+		if includeClaims {
+			claimsJSON, _ := json.MarshalIndent(tokenInfo.Extra, "", "  ")
+			text += "\nClaims: " + string(claimsJSON)
+		}
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: text},
+			},
+		}, nil, nil
+	case "json":
+		// Machine-readable output: the same JSON as text content and as structured content
+		result := map[string]any{"subject": sub, "scopes": tokenInfo.Scopes}
+		if values := StaticContextValues(ctx); len(values) > 0 {
+			result["context"] = values
+		}
+		if includeClaims {
+			result["claims"] = tokenInfo.Extra
+		}
+		resultJSON, _ := json.Marshal(result)
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: string(resultJSON)},
+			},
+			StructuredContent: result,
+		}, nil, nil
+	default:
+		return &mcp.CallToolResult{
+			IsError: true,
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: fmt.Sprintf("Invalid format %q: must be text or json", args.Format)},
+			},
+		}, nil, nil
+	}
+}
+
+// toolNames lists the tools registered with addTool, in registration order
+var toolNames []string
+
+// registeredTools lists the tools registered with addTool, in registration order
+var registeredTools []*mcp.Tool
+
+// toolScopes maps each tool registered with addTool to the scopes a caller needs to call it
+var toolScopes = make(map[string][]string)
+
+// toolAudiences maps tools to audiences a token's aud must include, beyond the resource itself, to call them
+var toolAudiences map[string][]string
+
+// toolsScope is the scope required by the general-purpose tools
+const toolsScope = "mcp:tools"
+
+// addTool registers a tool with the server and records its name.
+// Callers lacking any of requiredScopes, or denied by the tool policy, get an error result from the tool instead of
+// a transport-level 403, so the client sees why. Over stdio there is no token and the checks are skipped.
+func addTool[In, Out any](server *mcp.Server, stdio bool, tool *mcp.Tool, requiredScopes []string, handler mcp.ToolHandlerFor[In, Out]) {
+	mcp.AddTool(server, tool, func(ctx context.Context, req *mcp.CallToolRequest, in In) (*mcp.CallToolResult, Out, error) {
+		toolCallsTotal.WithLabelValues(tool.Name).Inc()
+		if denial := toolCallDenial(tool.Name, requiredScopes, stdio, req); denial != "" {
+			var zero Out
+			return &mcp.CallToolResult{
+				IsError: true,
+				Content: []mcp.Content{
+					&mcp.TextContent{Text: denial},
+				},
+			}, zero, nil
+		}
+		return handler(ctx, req, in)
+	})
+	// SSE sessions register the tools on servers of their own; record each tool once
+	if _, ok := toolScopes[tool.Name]; ok {
+		return
+	}
+	toolNames = append(toolNames, tool.Name)
+	registeredTools = append(registeredTools, tool)
+	toolScopes[tool.Name] = requiredScopes
+}
+
+// logToolInventory logs each registered tool with the scopes needed to call it, beyond the requiredScopes of every request,
+// so operators can confirm the active tool set after config changes
+func logToolInventory(requiredScopes []string) {
+	for _, tool := range registeredTools {
+		logger.Info("Registered tool", "name", tool.Name, "enabled", true, "required_scopes", append(slices.Clone(requiredScopes), toolScopes[tool.Name]...), "description", tool.Description)
+	}
+}
+
+// toolCallDenial explains why the caller may not call the tool, or returns "" when the call is authorized.
+// Over HTTP every call must carry the validated token; a call without one is denied rather than left unchecked.
+// Tool rate limits are enforced here only over stdio: over HTTP, ToolRateLimiter.Middleware answers 429 instead.
+func toolCallDenial(name string, requiredScopes []string, stdio bool, req *mcp.CallToolRequest) string {
+	if !stdio {
+		return toolTokenDenial(name, requiredScopes, req)
+	}
+	if toolRateLimiter != nil {
+		if delay := toolRateLimiter.Reserve(name, ""); delay > 0 {
+			logger.Warn("Rejected tool call: rate limit exceeded", "tool", name, "retry_after", delay.Round(time.Second))
+			return fmt.Sprintf("Rate limit exceeded for the %s tool; retry after %d seconds", name, int(math.Ceil(delay.Seconds())))
+		}
+	}
+	return ""
+}
+
+// toolTokenDenial checks the call's token against the tool's scopes, the wildcard scope guard and the tool policy
+func toolTokenDenial(name string, requiredScopes []string, req *mcp.CallToolRequest) string {
+	if req.Extra == nil || req.Extra.TokenInfo == nil {
+		logger.Warn("Rejected tool call without token information", "tool", name)
+		return fmt.Sprintf("The %s tool requires an authenticated caller", name)
+	}
+	if aud := missingToolAudience(name, req.Extra.TokenInfo.Extra); aud != "" {
+		logger.Warn("Rejected tool call: token audience does not include the tool's audience", "tool", name, "audience", aud)
+		return fmt.Sprintf("invalid_token: the %s tool requires a token issued for the audience %s", name, aud)
+	}
+	for _, scope := range requiredScopes {
+		if !slices.Contains(req.Extra.TokenInfo.Scopes, scope) {
+			logger.Warn("Rejected tool call: missing scope", "tool", name, "scope", scope)
+			return fmt.Sprintf("The %s tool requires the %s scope", name, scope)
+		}
+	}
+	if wildcardScopeGuard != nil {
+		if scope := wildcardScopeGuard.BroadScope(name, req.Extra.TokenInfo.Scopes); scope != "" {
+			logger.Warn("Rejected call to sensitive tool: token scope is too broad", "tool", name, "scope", scope)
+			return fmt.Sprintf("The token scope %q is too broad for the %s tool", scope, name)
+		}
+	}
+	if toolPolicy != nil {
+		if allowed, decidedBy := toolPolicy.Allowed(name, req.Extra.TokenInfo.Extra); !allowed {
+			logger.Warn("Rejected tool call: denied by policy", "tool", name, "decided_by", decidedBy)
+			return fmt.Sprintf("Calling the %s tool is denied by policy", name)
+		}
+	}
+	return ""
+}
+
+// missingToolAudience returns the first audience required by the tool that the token's aud lacks, or ""
+func missingToolAudience(name string, claims map[string]any) string {
+	audiences := tokenAudiences(claims)
+	for _, required := range toolAudiences[name] {
+		if !slices.ContainsFunc(audiences, func(aud string) bool { return canonicalURL(aud) == canonicalURL(required) }) {
+			return required
+		}
+	}
+	return ""
+}
+
+// newServer creates the MCP server with all tools registered, shared by every transport.
+// stdio disables the per-call authorization of tools, since there is no token over stdio.
+func newServer(staticValues map[string]string, stdio bool) *mcp.Server {
 	server := mcp.NewServer(&mcp.Implementation{
-		Name:    "simple-mcp-server",
-		Version: "1.0.0",
+		Name:    serverName,
+		Version: serverVersion,
 	}, nil)
+	if len(staticValues) > 0 {
+		server.AddReceivingMiddleware(StaticContextMiddleware(staticValues))
+	}
 
-	mcp.AddTool(server, &mcp.Tool{
+	addTool(server, stdio, &mcp.Tool{
 		Name:        "echo",
 		Description: "Echoes back the input message",
 		InputSchema: map[string]any{
@@ -57,31 +286,807 @@ func main() {
 			},
 			"required": []string{"message"},
 		},
-	}, Echo)
+	}, []string{toolsScope}, Echo)
 
-	// MCP handler
-	mcpHandler := mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server {
+	addTool(server, stdio, &mcp.Tool{
+		Name:        "base64",
+		Description: "Encodes or decodes data as base64",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"mode": map[string]any{
+					"type":        "string",
+					"enum":        []string{"encode", "decode"},
+					"description": "Whether to encode or decode the data",
+				},
+				"data": map[string]any{
+					"type":        "string",
+					"description": "The text to encode, or the base64 string to decode",
+				},
+			},
+			"required": []string{"mode", "data"},
+		},
+	}, []string{toolsScope}, Base64)
+
+	addTool(server, stdio, &mcp.Tool{
+		Name:        "whoami",
+		Description: "Returns the authenticated caller's subject and scopes (full claims with the " + whoamiClaimsScope + " scope)",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"format": map[string]any{
+					"type":        "string",
+					"enum":        []string{"text", "json"},
+					"description": "Output format: human-readable text (default) or machine JSON",
+				},
+			},
+		},
+	}, nil, Whoami)
+	return server
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// defaultListenAddr is the TCP address served when no other is configured
+const defaultListenAddr = ":8000"
+
+// envOr returns flagValue when it was changed from defaultValue, otherwise the envKey environment variable if set,
+// so the precedence is flag > environment > default
+func envOr(flagValue, defaultValue, envKey string) string {
+	if flagValue != defaultValue {
+		return flagValue
+	}
+	if v, ok := os.LookupEnv(envKey); ok {
+		return v
+	}
+	return defaultValue
+}
+
+// resolveListenAddr returns the TCP addresses to listen on: listen when set, otherwise MCP_LISTEN_ADDR or the default.
+// Only an explicit listen conflicts with unixSocket; MCP_LISTEN_ADDR is ignored when a Unix socket is served.
+func resolveListenAddr(listen, unixSocket string) (string, error) {
+	if unixSocket != "" {
+		if listen != defaultListenAddr {
+			return "", errors.New("-listen and -unix-socket are mutually exclusive")
+		}
+		return listen, nil
+	}
+	return envOr(listen, defaultListenAddr, "MCP_LISTEN_ADDR"), nil
+}
+
+// listenUnix listens on a Unix domain socket, removing a stale socket file left by a previous run
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on unix socket: %w", err)
+	}
+	// Allow the owner and group (e.g. the sidecar) to connect
+	if err := os.Chmod(path, 0660); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return ln, nil
+}
+
+// listenTCP listens on every address, closing the listeners already opened when one fails
+func listenTCP(addrs []string) ([]net.Listener, error) {
+	var lns []net.Listener
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// listenerAddrs returns the addresses the listeners are bound to, e.g. the port actually chosen for :0
+func listenerAddrs(lns []net.Listener) string {
+	addrs := make([]string, len(lns))
+	for i, ln := range lns {
+		addrs[i] = ln.Addr().String()
+	}
+	return strings.Join(addrs, ", ")
+}
+
+// serveListeners serves srv on every listener, sending each listener's Serve error.
+// A single srv.Shutdown stops all of them.
+func serveListeners(srv *http.Server, lns []net.Listener, useTLS bool) <-chan error {
+	serveErr := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func() {
+			var err error
+			if useTLS {
+				err = srv.ServeTLS(ln, "", "")
+			} else {
+				err = srv.Serve(ln)
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				err = fmt.Errorf("%s: %w", ln.Addr(), err)
+			}
+			serveErr <- err
+		}()
+	}
+	return serveErr
+}
+
+func main() {
+	// Parse command line flags
+	authzServerURL := flag.String("authz-server-url", "http://localhost/realms/demo", "Authorization Server URL")
+	jwksURL := flag.String("jwks-url", "http://localhost/realms/demo/protocol/openid-connect/certs", "JWKS URL")
+	resourceURL := flag.String("resource-url", "http://localhost:8000", "Resource URL for this server")
+	proxyASMetadata := flag.Bool("proxy-as-metadata", false, "Serve the authorization server metadata at /.well-known/oauth-authorization-server with permissive CORS headers")
+	asMetadataTTL := flag.Duration("as-metadata-ttl", 5*time.Minute, "How long the metadata served by -proxy-as-metadata is cached")
+	authorizationServers := flag.String("authorization-servers", "", "Comma-separated list of Authorization Server URLs to advertise (defaults to -authz-server-url)")
+	gatewaySecretHeader := flag.String("gateway-secret-header", "", "Header carrying the API gateway shared secret (disabled when empty)")
+	gatewaySecret := flag.String("gateway-secret", "", "Shared secret expected in -gateway-secret-header")
+	transport := flag.String("transport", "http", "MCP transport: http (OAuth-protected) or stdio (local subprocess, no authorization)")
+	listen := flag.String("listen", defaultListenAddr, "Comma-separated TCP addresses to listen on, e.g. 127.0.0.1:8000,[::1]:8000, or :0 for an ephemeral port")
+	unixSocket := flag.String("unix-socket", "", "Serve on this Unix domain socket path instead of TCP -listen")
+	subPattern := flag.String("sub-pattern", "", "Regular expression the sub claim must match (disabled when empty)")
+	errorVerbosity := flag.String("error-verbosity", "terse", "Detail in error responses: terse or verbose")
+	sessionExpiryGrace := flag.Duration("session-expiry-grace", 0, "How long a streaming session may continue after its access token expires")
+	hashLogSubjects := flag.Bool("hash-log-subjects", false, "Replace sub values in logs with a salted hash")
+	debugAuth := flag.Bool("debug-auth", false, "Log each access token's decoded header and non-sensitive claims at debug level (never the raw token)")
+	logSubjectSalt := flag.String("log-subject-salt", "", "Salt used by -hash-log-subjects")
+	requireProtocolVersion := flag.Bool("require-protocol-version", false, "Reject MCP requests without an MCP-Protocol-Version header")
+	audienceFromRequestURL := flag.Bool("audience-from-request-url", false, "Validate aud against the absolute request URL instead of -resource-url (strict)")
+	trustProxyHeaders := flag.Bool("trust-proxy-headers", false, "Trust X-Forwarded-Proto/X-Forwarded-Host when reconstructing the request URL")
+	adminToken := flag.String("admin-token", "", "Bearer token for /admin endpoints (disabled when empty)")
+	advertiseTools := flag.Bool("advertise-tools-in-metadata", false, "List tool names in the protected resource metadata (discloses capabilities before authentication)")
+	securityLog := flag.String("security-log", "", "Destination for auth decision records: stdout, stderr, or a file path (disabled when empty)")
+	rejectSuspiciousHeaders := flag.Bool("reject-suspicious-headers", false, "Reject requests with CR/LF in headers or duplicate security-relevant headers (e.g. Authorization) with 400")
+	allowedOrigins := flag.String("allowed-origins", "*", "Comma-separated origins allowed to call this server from a browser (CORS), or * for any origin")
+	allowedHosts := flag.String("allowed-hosts", "", "Comma-separated list of accepted Host header values (any host when empty)")
+	lazyJWKS := flag.Bool("lazy-jwks", false, "Defer fetching the JWKS until the first token needs validation")
+	warmupTimeout := flag.Duration("warmup-timeout", 30*time.Second, "How long /readyz reports 503 at most while the JWKS and authorization server metadata are fetched at startup; the JWKS is skipped with -lazy-jwks (0 to disable)")
+	claimHeaders := flag.String("claim-headers", "", "Comma-separated claim=Header mappings forwarded downstream (e.g. sub=X-User-Id)")
+	contextValues := flag.String("context-values", "", "Comma-separated key=value pairs added to every MCP request's context for tools (e.g. tenant=acme,env=prod)")
+	forwardAccessToken := flag.Bool("forward-access-token", false, "Keep the Authorization header on requests passed to the MCP handler")
+	landingPage := flag.Bool("landing-page", false, "Serve a short HTML page to browsers visiting / without credentials")
+	maxStreamsPerSubject := flag.Int("max-streams-per-subject", 0, "Maximum concurrent streaming sessions per sub (0 = unlimited)")
+	audiencesFile := flag.String("audiences-file", "", "File listing additional accepted audiences, one URL per line (reloaded on change)")
+	audiencesFileInterval := flag.Duration("audiences-file-interval", 30*time.Second, "How often -audiences-file is checked for changes")
+	policyFile := flag.String("policy-file", "", "YAML or JSON file of rules allowing or denying tool calls by token claims (reloaded on change)")
+	policyFileInterval := flag.Duration("policy-file-interval", 30*time.Second, "How often -policy-file is checked for changes")
+	devToken := flag.String("dev-token", "", "Static bearer token accepted without JWT validation (development only; disabled when empty)")
+	devSubject := flag.String("dev-subject", "dev-user", "Subject injected for -dev-token")
+	devScopes := flag.String("dev-scopes", "mcp:tools", "Comma-separated scopes injected for -dev-token")
+	traceDecisions := flag.Bool("trace-decisions", false, "Log the per-request middleware decision chain at debug level on completion")
+	jwksUnknownKIDRefreshInterval := flag.Duration("jwks-unknown-kid-refresh-interval", 5*time.Minute, "Minimum interval between JWKS refreshes triggered by unknown key IDs")
+	errorFormatFlag := flag.String("error-format", "jsonrpc", "Error response body format: jsonrpc or problem (RFC 9457 application/problem+json)")
+	allowedKIDs := flag.String("allowed-kids", "", "Comma-separated key IDs allowed to sign tokens (any kid in the JWKS when empty)")
+	deprecatedKIDs := flag.String("deprecated-kids", "", "Comma-separated key IDs being retired; tokens they sign are accepted but logged as a warning")
+	tokenVersionClaim := flag.String("token-version-claim", "ver", "Claim carrying the token format version")
+	tokenVersion := flag.String("token-version", "", "Required exact value of the token version claim (disabled when empty)")
+	minTokenVersion := flag.Float64("min-token-version", 0, "Minimum numeric value of the token version claim (disabled when 0)")
+	exclusiveAudience := flag.Bool("exclusive-audience", false, "Reject tokens whose aud contains any value other than the accepted audiences")
+	maxAudiences := flag.Int("max-audiences", 0, "Reject tokens whose aud has more entries than this (disabled when 0)")
+	jwksFailureMode := flag.String("jwks-failure-mode", "closed", "Behavior when the JWKS cannot be fetched: closed (reject) or open (accept tokens without signature verification)")
+	allowedAlgorithms := flag.String("allowed-algorithms", "RS256", "Comma-separated accepted JWT signing algorithms (e.g. RS256,ES256,PS256)")
+	logToolRegistrations := flag.Bool("log-tool-registrations", false, "Log name, description and required scopes of each tool registered at startup")
+	jwksRefreshInterval := flag.Duration("jwks-refresh-interval", time.Hour, "How often the JWKS is refreshed in the background")
+	introspectionURL := flag.String("introspection-url", "", "RFC 7662 token introspection endpoint for opaque tokens (disabled when empty)")
+	introspectionClientID := flag.String("introspection-client-id", "", "Client ID used to authenticate to the introspection endpoint")
+	introspectionClientSecret := flag.String("introspection-client-secret", "", "Client secret used to authenticate to the introspection endpoint")
+	introspectionTimeout := flag.Duration("introspection-timeout", 10*time.Second, "Timeout of each introspection attempt")
+	introspectionRetries := flag.Int("introspection-retries", 0, "How many times a failed introspection call (network error, timeout or 5xx) is retried")
+	introspectionBreakerThreshold := flag.Int("introspection-breaker-threshold", 0, "Stop calling the introspection endpoint for -introspection-breaker-cooldown after this many consecutive failures (disabled when 0)")
+	introspectionBreakerCooldown := flag.Duration("introspection-breaker-cooldown", 30*time.Second, "How long the introspection circuit stays open")
+	introspectionBreakerMode := flag.String("introspection-breaker-mode", "unavailable", "Response while the introspection circuit is open: unavailable (503 with Retry-After) or closed (401 invalid_token)")
+	certBoundTokens := flag.Bool("require-cert-bound-tokens", false, "Require tokens bound to the TLS client certificate by their cnf x5t#S256 thumbprint, RFC 8705 (requires -tls-client-ca)")
+	nonceHeader := flag.String("nonce-header", "", "Request header with the nonce the token's nonce claim must match, when sent (disabled when empty)")
+	maxTokensPerSubject := flag.Int("max-tokens-per-subject", 0, "Maximum distinct tokens (jti) a subject may present within -max-tokens-window (0 for unlimited)")
+	maxTokensWindow := flag.Duration("max-tokens-window", time.Hour, "Window for -max-tokens-per-subject")
+	tokenCacheSize := flag.Int("token-cache-size", 0, "Maximum number of verified tokens cached to skip signature verification on reuse (0 to disable)")
+	requiredScopes := flag.String("required-scopes", "mcp:tools", "Comma-separated scopes every token must carry (scope validation is skipped when empty)")
+	scopeMatch := flag.String("scope-match", "all", "Whether tokens must carry all of -required-scopes or any one of them: all or any")
+	ssePath := flag.String("sse-path", "", "Also serve the MCP endpoint over the SSE transport at this path, e.g. /sse (disabled when empty)")
+	jsonRPCPath := flag.String("json-rpc-path", "", "Also serve a stateless plain HTTP JSON-RPC MCP endpoint at this path, e.g. /rpc (disabled when empty)")
+	acceptedAudiences := flag.String("accepted-audiences", "", "Comma-separated audiences accepted for this resource (default: -resource-url)")
+	metricsEnabled := flag.Bool("metrics", false, "Serve Prometheus metrics at /metrics (no authorization required)")
+	versionEndpoint := flag.Bool("version-endpoint", true, "Serve build and runtime information at /version")
+	clockSkew := flag.Duration("clock-skew", 60*time.Second, "Leeway allowed when validating exp, nbf and iat")
+	inlineJWKS := flag.String("jwks", "", "Inline JWK Set JSON used instead of -jwks-url, without network access (usually an object under the jwks key of -config)")
+	jwksCacheFile := flag.String("jwks-cache-file", "", "File the fetched JWKS is persisted to and used from at startup when the JWKS cannot be fetched (disabled when empty)")
+	jwksCacheMaxAge := flag.Duration("jwks-cache-max-age", 24*time.Hour, "Maximum age of a cached JWKS used at startup")
+	jwksMaxStaleness := flag.Duration("jwks-max-staleness", 0, "Reject tokens once the JWKS has not been refreshed successfully for this long; until then the last-good keys are used (disabled when 0)")
+	toolRateLimits := flag.String("tool-rate-limits", "", "Comma-separated per-tool call limits as tool=N/unit (unit: s, m or h), e.g. base64=10/m")
+	rateLimit := flag.Float64("rate-limit", 0, "Requests per second allowed per subject (per remote IP without one); 0 disables rate limiting")
+	rateBurst := flag.Int("rate-burst", 20, "Requests a subject may send in a burst above -rate-limit")
+	toolRateLimitPerSubject := flag.Bool("tool-rate-limit-per-subject", false, "Apply -tool-rate-limits to each subject separately instead of to all callers together")
+	dangerousScopes := flag.String("dangerous-scopes", "", "Comma-separated overly broad scopes (e.g. *,mcp:*) that may not call -sensitive-tools")
+	sensitiveTools := flag.String("sensitive-tools", "", "Comma-separated tools refused to tokens holding a -dangerous-scopes scope")
+	trustedIssuers := flag.String("trusted-issuers", "", "Comma-separated accepted token issuers (default: -authz-server-url)")
+	issuerJwks := flag.String("issuer-jwks", "", "Comma-separated issuer=jwks-url pairs for federated issuers with their own signing keys (issuers are trusted automatically)")
+	toolAudiencesFlag := flag.String("tool-audiences", "", "Comma-separated tool=audience requirements; calls to the tool need a token whose aud also includes the audience (e.g. admin=https://admin.example)")
+	scopeAudienceRules := flag.String("scope-audience-rules", "", "Comma-separated scope-prefix=audience rules; tokens with a matching scope must include the audience (e.g. resourceX:=https://x.example)")
+	enablePprof := flag.Bool("enable-pprof", false, "Serve net/http/pprof profiling handlers on -pprof-addr")
+	pprofAddr := flag.String("pprof-addr", "127.0.0.1:6060", "Listen address of the pprof server; non-loopback addresses require -admin-token")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file; serves HTTPS together with -tls-key (reloaded on SIGHUP)")
+	tlsKey := flag.String("tls-key", "", "TLS private key file for -tls-cert")
+	tlsClientCA := flag.String("tls-client-ca", "", "PEM file of the CAs client certificates are verified against; clients may then present a certificate (requires -tls-cert)")
+	clientCertPaths := flag.String("client-cert-paths", "", "Comma-separated path prefixes that require a client certificate verified against -tls-client-ca, e.g. /admin (403 without one)")
+	requireSNIMatch := flag.Bool("require-sni-match", false, "Reject TLS requests whose SNI server name is not the -resource-url host with 421 (requires -tls-cert)")
+	requestTimeout := flag.Duration("request-timeout", 30*time.Second, "Maximum duration of a non-streaming MCP request, e.g. a tool call; timed out requests get 503 (0 for unlimited)")
+	requestTimeoutExemptPaths := flag.String("request-timeout-exempt-paths", "", "Comma-separated MCP paths whose non-streaming requests are not bounded by -request-timeout")
+	streamingTimeout := flag.Duration("streaming-timeout", 0, "Maximum duration of a streaming (GET text/event-stream) MCP session (0 for unlimited)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "How long to wait for in-flight requests to finish on SIGINT/SIGTERM")
+	configFile := flag.String("config", "", "YAML or JSON file setting any of these flags by name; command-line flags take precedence")
+	logLevel := flag.String("log-level", "info", "Log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "Log output format: text or json")
+	logBodiesFlag := flag.Bool("log-bodies", true, "Log JSON request bodies at debug level (tool arguments may contain secrets)")
+	maxLoggedBodyBytes := flag.Int("max-logged-body-bytes", MaxLoggedBodyBytes, "Truncate logged request bodies to this many bytes")
+	flag.Parse()
+
+	if *configFile != "" {
+		if err := LoadConfigFile(*configFile); err != nil {
+			log.Fatalf("Invalid config file %s:\n%v", *configFile, err)
+		}
+	}
+
+	// Container deployments may configure the essentials through the environment instead
+	var err error
+	*authzServerURL = envOr(*authzServerURL, flag.Lookup("authz-server-url").DefValue, "MCP_AUTHZ_SERVER_URL")
+	*jwksURL = envOr(*jwksURL, flag.Lookup("jwks-url").DefValue, "MCP_JWKS_URL")
+	*resourceURL = envOr(*resourceURL, flag.Lookup("resource-url").DefValue, "MCP_RESOURCE_URL")
+	*requiredScopes = envOr(*requiredScopes, flag.Lookup("required-scopes").DefValue, "MCP_REQUIRED_SCOPES")
+	if *listen, err = resolveListenAddr(*listen, *unixSocket); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if logger, err = NewLogger(*logLevel, *logFormat); err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+	// Route the remaining log.Printf calls through the structured logger at info level
+	slog.SetDefault(logger)
+	logBodies = *logBodiesFlag
+	MaxLoggedBodyBytes = *maxLoggedBodyBytes
+
+	// SIGINT/SIGTERM cancel ctx, stopping background workers and starting a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *errorVerbosity != "terse" && *errorVerbosity != "verbose" {
+		log.Fatalf("Invalid -error-verbosity %q: must be terse or verbose", *errorVerbosity)
+	}
+
+	if *errorFormatFlag != "jsonrpc" && *errorFormatFlag != "problem" {
+		log.Fatalf("Invalid -error-format %q: must be jsonrpc or problem", *errorFormatFlag)
+	}
+	errorFormat = *errorFormatFlag
+
+	if *transport != "http" && *transport != "stdio" {
+		log.Fatalf("Invalid -transport %q: must be http or stdio", *transport)
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatalf("-tls-cert and -tls-key must be set together")
+	}
+	if *tlsClientCA != "" && *tlsCert == "" {
+		log.Fatalf("-tls-client-ca requires -tls-cert and -tls-key")
+	}
+	if *clientCertPaths != "" && *tlsClientCA == "" {
+		log.Fatalf("-client-cert-paths requires -tls-client-ca")
+	}
+	if *certBoundTokens && *tlsClientCA == "" {
+		log.Fatalf("-require-cert-bound-tokens requires -tls-client-ca")
+	}
+	if *requireSNIMatch && *tlsCert == "" {
+		log.Fatalf("-require-sni-match requires -tls-cert and -tls-key")
+	}
+	var certReloader *CertReloader
+	if *tlsCert != "" {
+		if certReloader, err = NewCertReloader(*tlsCert, *tlsKey); err != nil {
+			log.Fatalf("Invalid TLS configuration: %v", err)
+		}
+		// Advertise an https resource unless one was configured
+		if *resourceURL == flag.Lookup("resource-url").DefValue {
+			*resourceURL = "https://localhost:8000"
+		}
+	}
+	var clientCAs *x509.CertPool
+	if *tlsClientCA != "" {
+		if clientCAs, err = LoadClientCAs(*tlsClientCA); err != nil {
+			log.Fatalf("Invalid TLS configuration: %v", err)
+		}
+	}
+
+	issuerJwksURLs := make(map[string]string)
+	for _, pair := range splitList(*issuerJwks) {
+		iss, u, ok := strings.Cut(pair, "=")
+		if !ok || iss == "" || u == "" {
+			log.Fatalf("Invalid -issuer-jwks entry %q: expected issuer=jwks-url", pair)
+		}
+		issuerJwksURLs[iss] = u
+	}
+	scopeAudiences := make(map[string]string)
+	for _, rule := range splitList(*scopeAudienceRules) {
+		prefix, aud, ok := strings.Cut(rule, "=")
+		if !ok || prefix == "" || aud == "" {
+			log.Fatalf("Invalid -scope-audience-rules entry %q: expected scope-prefix=audience", rule)
+		}
+		scopeAudiences[prefix] = aud
+	}
+	for _, rule := range splitList(*toolAudiencesFlag) {
+		tool, aud, ok := strings.Cut(rule, "=")
+		if !ok || tool == "" || aud == "" {
+			log.Fatalf("Invalid -tool-audiences entry %q: expected tool=audience", rule)
+		}
+		if toolAudiences == nil {
+			toolAudiences = make(map[string][]string)
+		}
+		toolAudiences[tool] = append(toolAudiences[tool], aud)
+	}
+	toolLimits, err := ParseToolRateLimits(*toolRateLimits)
+	if err != nil {
+		log.Fatalf("Invalid -tool-rate-limits: %v", err)
+	}
+	if *rateLimit < 0 || (*rateLimit > 0 && *rateBurst < 1) {
+		log.Fatalf("Invalid -rate-limit %v / -rate-burst %d: the limit must not be negative and the burst must be at least 1", *rateLimit, *rateBurst)
+	}
+	if *jwksURL == "" && *inlineJWKS == "" && *introspectionURL == "" {
+		log.Fatalf("Either -jwks-url, -jwks or -introspection-url must be set")
+	}
+	if *introspectionBreakerMode != "unavailable" && *introspectionBreakerMode != "closed" {
+		log.Fatalf("Invalid -introspection-breaker-mode %q: must be unavailable or closed", *introspectionBreakerMode)
+	}
+	if *introspectionRetries < 0 || *introspectionBreakerThreshold < 0 {
+		log.Fatalf("-introspection-retries and -introspection-breaker-threshold must not be negative")
+	}
+	if *scopeMatch != "all" && *scopeMatch != "any" {
+		log.Fatalf("Invalid -scope-match %q: must be all or any", *scopeMatch)
+	}
+	if *jwksFailureMode != "closed" && *jwksFailureMode != "open" {
+		log.Fatalf("Invalid -jwks-failure-mode %q: must be closed or open", *jwksFailureMode)
+	}
+	if *jwksMaxStaleness > 0 {
+		refreshInterval := *jwksRefreshInterval
+		if refreshInterval <= 0 {
+			refreshInterval = time.Hour
+		}
+		// Keys become stale by design between refreshes, so a shorter window would reject valid tokens every cycle
+		if *jwksMaxStaleness <= refreshInterval {
+			log.Fatalf("-jwks-max-staleness %v must be longer than the JWKS refresh interval %v", *jwksMaxStaleness, refreshInterval)
+		}
+	}
+
+	if (*gatewaySecretHeader == "") != (*gatewaySecret == "") {
+		log.Fatalf("-gateway-secret-header and -gateway-secret must be set together")
+	}
+
+	// Initialize OAuth config
+	oauthConfig := &OAuthConfig{
+		AuthzServerURL:                 *authzServerURL,
+		JwksURL:                        *jwksURL,
+		InlineJWKS:                     *inlineJWKS,
+		ResourceURL:                    *resourceURL,
+		AuthorizationServers:           splitList(*authorizationServers),
+		VerboseErrors:                  *errorVerbosity == "verbose",
+		SessionExpiryGrace:             *sessionExpiryGrace,
+		HashLogSubjects:                *hashLogSubjects,
+		DebugAuth:                      *debugAuth,
+		LogSubjectSalt:                 *logSubjectSalt,
+		AudienceFromRequestURL:         *audienceFromRequestURL,
+		TrustProxyHeaders:              *trustProxyHeaders,
+		LazyJWKS:                       *lazyJWKS,
+		ForwardAccessToken:             *forwardAccessToken,
+		AudiencesFile:                  *audiencesFile,
+		DevToken:                       *devToken,
+		DevSubject:                     *devSubject,
+		DevScopes:                      splitList(*devScopes),
+		JwksUnknownKIDRefreshInterval:  *jwksUnknownKIDRefreshInterval,
+		AllowedKIDs:                    splitList(*allowedKIDs),
+		DeprecatedKIDs:                 splitList(*deprecatedKIDs),
+		JwksFailOpen:                   *jwksFailureMode == "open",
+		AllowedAlgorithms:              splitList(*allowedAlgorithms),
+		JwksRefreshInterval:            *jwksRefreshInterval,
+		TrustedIssuers:                 splitList(*trustedIssuers),
+		IssuerJwksURLs:                 issuerJwksURLs,
+		JwksCacheFile:                  *jwksCacheFile,
+		JwksCacheMaxAge:                *jwksCacheMaxAge,
+		JwksMaxStaleness:               *jwksMaxStaleness,
+		IntrospectionURL:               *introspectionURL,
+		IntrospectionClientID:          *introspectionClientID,
+		IntrospectionClientSecret:      *introspectionClientSecret,
+		IntrospectionTimeout:           *introspectionTimeout,
+		IntrospectionRetries:           *introspectionRetries,
+		IntrospectionBreakerThreshold:  *introspectionBreakerThreshold,
+		IntrospectionBreakerCooldown:   *introspectionBreakerCooldown,
+		IntrospectionBreakerFailClosed: *introspectionBreakerMode == "closed",
+		ExclusiveAudience:              *exclusiveAudience,
+		MaxAudiences:                   *maxAudiences,
+		RequiredScopes:                 splitList(*requiredScopes),
+		AnyRequiredScope:               *scopeMatch == "any",
+		ScopeAudienceRules:             scopeAudiences,
+		AcceptedAudiences:              splitList(*acceptedAudiences),
+		ClockSkew:                      *clockSkew,
+		NonceHeader:                    *nonceHeader,
+		CertBoundTokens:                *certBoundTokens,
+		TokenVersionClaim:              *tokenVersionClaim,
+		TokenVersion:                   *tokenVersion,
+		MinTokenVersion:                *minTokenVersion,
+	}
+
+	for _, mapping := range splitList(*claimHeaders) {
+		claim, header, ok := strings.Cut(mapping, "=")
+		if !ok || claim == "" || header == "" {
+			log.Fatalf("Invalid -claim-headers entry %q: expected claim=Header", mapping)
+		}
+		if oauthConfig.ClaimHeaders == nil {
+			oauthConfig.ClaimHeaders = make(map[string]string)
+		}
+		oauthConfig.ClaimHeaders[claim] = header
+	}
+
+	if *securityLog != "" {
+		securityLogger, err := OpenSecurityLog(*securityLog)
+		if err != nil {
+			log.Fatalf("Failed to open security log: %v", err)
+		}
+		oauthConfig.SecurityLog = securityLogger
+	}
+
+	if *subPattern != "" {
+		re, err := regexp.Compile(*subPattern)
+		if err != nil {
+			log.Fatalf("Invalid -sub-pattern: %v", err)
+		}
+		oauthConfig.SubPattern = re
+	}
+
+	var staticValues map[string]string
+	if values := splitList(*contextValues); len(values) > 0 {
+		staticValues = make(map[string]string, len(values))
+		for _, pair := range values {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok || key == "" {
+				log.Fatalf("Invalid -context-values entry %q: expected key=value", pair)
+			}
+			staticValues[key] = value
+		}
+	}
+
+	// Local clients launch the server as a subprocess over stdio; there is no HTTP request to authorize
+	if *transport == "stdio" {
+		log.Printf("Starting MCP server on stdio")
+		if err := newServer(staticValues, true).Run(ctx, &mcp.StdioTransport{}); err != nil {
+			logger.Error("Server failed", "error", err)
+		}
+		return
+	}
+
+	if *devToken != "" {
+		logger.Warn("-dev-token is set; requests bearing it bypass JWT validation. Never use this in production.", "subject", *devSubject)
+	}
+
+	if *audiencesFile != "" {
+		if err := oauthConfig.LoadAudiencesFile(); err != nil {
+			log.Fatalf("Failed to load audiences file: %v", err)
+		}
+		go oauthConfig.WatchAudiencesFile(ctx, *audiencesFileInterval)
+	}
+
+	if len(toolLimits) > 0 {
+		toolRateLimiter = NewToolRateLimiter(toolLimits, *toolRateLimitPerSubject)
+	}
+	if *dangerousScopes != "" && *sensitiveTools != "" {
+		wildcardScopeGuard = NewWildcardScopeGuard(splitList(*dangerousScopes), splitList(*sensitiveTools))
+	}
+
+	if *policyFile != "" {
+		policy, err := NewToolPolicy(*policyFile)
+		if err != nil {
+			log.Fatalf("Failed to load policy file: %v", err)
+		}
+		toolPolicy = policy
+		go policy.Watch(ctx, *policyFileInterval)
+	}
+
+	if err := oauthConfig.InitJWKS(); err != nil {
+		log.Fatalf("Failed to initialize JWKS: %v", err)
+	}
+	defer oauthConfig.Close()
+
+	server := newServer(staticValues, false)
+
+	if *tokenCacheSize > 0 {
+		oauthConfig.TokenCache = NewTokenCache(*tokenCacheSize)
+	}
+
+	if *maxTokensPerSubject > 0 {
+		oauthConfig.JTITracker = NewJTITracker(*maxTokensPerSubject, *maxTokensWindow)
+	}
+
+	if *logToolRegistrations {
+		logToolInventory(oauthConfig.RequiredScopes)
+	}
+
+	if *advertiseTools {
+		oauthConfig.AdvertisedTools = toolNames
+	}
+
+	getServer := func(*http.Request) *mcp.Server {
 		return server
-	}, nil)
+	}
+
+	// MCP handler
+	var mcpHandler http.Handler = TraceHandler("handler", TimingHandler(mcp.NewStreamableHTTPHandler(getServer, nil)))
+	if *requireProtocolVersion {
+		mcpHandler = RequireProtocolVersionMiddleware(mcpHandler)
+	}
+
+	// SNI matching guards the routes that accept tokens (MCP and admin); probes and metadata stay reachable
+	// without the resource's server name, e.g. by load balancers connecting by IP
+	requireSNI := func(h http.Handler) http.Handler { return h }
+	if *requireSNIMatch {
+		u, err := url.Parse(*resourceURL)
+		if err != nil || u.Hostname() == "" {
+			log.Fatalf("-require-sni-match needs a -resource-url with a host")
+		}
+		requireSNI = func(h http.Handler) http.Handler { return SNIMiddleware(u.Hostname(), h) }
+	}
 
 	// Setup routing
 	mux := http.NewServeMux()
 
 	// OAuth 2.1 metadata endpoint (no authorization required)
 	mux.HandleFunc("/.well-known/oauth-protected-resource", oauthConfig.HandleProtectedResourceMetadata)
+	var asMetadataProxy *ASMetadataProxy
+	if *proxyASMetadata {
+		asMetadataProxy = NewASMetadataProxy(*authzServerURL, *asMetadataTTL)
+		mux.HandleFunc("/.well-known/oauth-authorization-server", asMetadataProxy.HandleASMetadata)
+	}
+
+	// Load balancer probes (no authorization required)
+	mux.HandleFunc("/healthz", HandleHealthz)
+	mux.HandleFunc("/readyz", oauthConfig.HandleReadyz)
+
+	// Prometheus metrics (no authorization required)
+	if *metricsEnabled {
+		mux.Handle("/metrics", MetricsHandler())
+	}
+
+	// Build information for deployment verification (no authorization required)
+	if *versionEndpoint {
+		mux.HandleFunc("/version", HandleVersion)
+	}
+
+	// Admin endpoints (admin token required)
+	if *adminToken != "" {
+		mux.Handle("/admin/diagnostics", requireSNI(AdminMiddleware(*adminToken, http.HandlerFunc(oauthConfig.HandleDiagnostics))))
+		mux.Handle("/admin/maintenance", requireSNI(AdminMiddleware(*adminToken, http.HandlerFunc(HandleMaintenance))))
+	}
+
+	// protect applies the authorization chain shared by every MCP transport
+	var sessionLimiter *SessionLimiter
+	if *maxStreamsPerSubject > 0 {
+		sessionLimiter = NewSessionLimiter(*maxStreamsPerSubject)
+	}
+	var subjectRateLimiter *SubjectRateLimiter
+	if *rateLimit > 0 {
+		subjectRateLimiter = NewSubjectRateLimiter(*rateLimit, *rateBurst)
+	}
+	protect := func(h http.Handler) http.Handler {
+		if *requestTimeout > 0 || *streamingTimeout > 0 {
+			h = TimeoutMiddleware(*requestTimeout, *streamingTimeout, splitList(*requestTimeoutExemptPaths), h)
+		}
+		if toolRateLimiter != nil {
+			h = toolRateLimiter.Middleware(h)
+		}
+		if sessionLimiter != nil {
+			h = sessionLimiter.Middleware(h)
+		}
+		if subjectRateLimiter != nil {
+			h = subjectRateLimiter.Middleware(h)
+		}
+		return oauthConfig.OAuthMiddleware(h)
+	}
 
 	// MCP endpoint (OAuth authorization required, with logging)
-	mux.Handle("/", LoggingMiddleware(oauthConfig.OAuthMiddleware(mcpHandler)))
+	protectedHandler := protect(mcpHandler)
+	if *landingPage {
+		protectedHandler = LandingPageMiddleware(*resourceURL+"/.well-known/oauth-protected-resource", protectedHandler)
+	}
+	mux.Handle("/", requireSNI(MetricsMiddleware(LoggingMiddleware(MaintenanceMiddleware(protectedHandler)))))
+
+	// Additional transports share the same server and authorization chain
+	if *ssePath != "" {
+		// Each SSE session gets its own server bound to the token that opened it, since the SSE transport does not
+		// pass the token of each message to tools; the session ends when that token expires
+		sseServer := func(r *http.Request) *mcp.Server {
+			s := newServer(staticValues, false)
+			s.AddReceivingMiddleware(SessionTokenMiddleware(auth.TokenInfoFromContext(r.Context())))
+			return s
+		}
+		sseHandler := TraceHandler("handler", TimingHandler(mcp.NewSSEHandler(sseServer, nil)))
+		mux.Handle(*ssePath, requireSNI(MetricsMiddleware(LoggingMiddleware(MaintenanceMiddleware(protect(sseHandler))))))
+	}
+	if *jsonRPCPath != "" {
+		jsonHandler := TraceHandler("handler", TimingHandler(mcp.NewStreamableHTTPHandler(getServer, &mcp.StreamableHTTPOptions{
+			Stateless:    true,
+			JSONResponse: true,
+		})))
+		mux.Handle(*jsonRPCPath, requireSNI(MetricsMiddleware(LoggingMiddleware(MaintenanceMiddleware(protect(jsonHandler))))))
+	}
+
+	// SIGUSR1 toggles maintenance mode without a restart
+	maintenanceSignal := make(chan os.Signal, 1)
+	signal.Notify(maintenanceSignal, syscall.SIGUSR1)
+	go func() {
+		for range maintenanceSignal {
+			setMaintenanceMode(!maintenanceMode.Load())
+		}
+	}()
+
+	var handler http.Handler = CORSMiddleware(splitList(*allowedOrigins), mux)
+	if hosts := splitList(strings.ToLower(*allowedHosts)); len(hosts) > 0 {
+		handler = HostAllowlistMiddleware(hosts, handler)
+	}
+	if prefixes := splitList(*clientCertPaths); len(prefixes) > 0 {
+		handler = ClientCertMiddleware(prefixes, handler)
+	}
+	// Gateway shared secret is checked before any other processing
+	if *gatewaySecretHeader != "" {
+		handler = GatewaySecretMiddleware(*gatewaySecretHeader, *gatewaySecret, handler)
+	}
+	// Malformed headers are rejected before anything interprets them
+	if *rejectSuspiciousHeaders {
+		handler = HeaderInjectionMiddleware(oauthConfig.SecurityLog, handler)
+	}
+	if *traceDecisions {
+		handler = DecisionTraceMiddleware(handler)
+	}
+
+	// Profiling runs on its own listener so it is never reachable through the main port
+	if *enablePprof {
+		pprofHandler, err := newPprofServerHandler(*pprofAddr, *adminToken)
+		if err != nil {
+			log.Fatalf("Invalid -pprof-addr: %v", err)
+		}
+		go func() {
+			log.Printf("Serving pprof on %s/debug/pprof/", *pprofAddr)
+			if err := http.ListenAndServe(*pprofAddr, pprofHandler); err != nil {
+				logger.Error("pprof server failed", "error", err)
+			}
+		}()
+	}
 
-	log.Println("Starting MCP server on :8000")
+	listenAddr := strings.Join(splitList(*listen), ", ")
+	if *unixSocket != "" {
+		listenAddr = "unix:" + *unixSocket
+	}
+	scheme := "HTTP"
+	if certReloader != nil {
+		scheme = "HTTPS"
+	}
+	log.Printf("Starting MCP server (%s) on %s", scheme, listenAddr)
 	log.Printf("Authorization Server URL: %s", *authzServerURL)
-	log.Printf("JWKS URL: %s", *jwksURL)
+	if *inlineJWKS != "" {
+		log.Printf("JWKS: inline (%s is not fetched)", *jwksURL)
+	} else {
+		log.Printf("JWKS URL: %s", *jwksURL)
+	}
+	if *introspectionURL != "" {
+		log.Printf("Introspection URL: %s", *introspectionURL)
+	}
 	log.Printf("Resource URL: %s", *resourceURL)
-	log.Println("Tool available: echo")
+	log.Printf("Advertised Authorization Servers: %s", strings.Join(oauthConfig.authorizationServers(), ", "))
+	if *gatewaySecretHeader != "" {
+		log.Printf("Gateway secret required in header: %s", *gatewaySecretHeader)
+	}
+	log.Printf("Tools available: %s", strings.Join(toolNames, ", "))
+	log.Println("MCP endpoints:")
+	log.Println("  - / (streamable HTTP)")
+	if *ssePath != "" {
+		log.Printf("  - %s (SSE)", *ssePath)
+	}
+	if *jsonRPCPath != "" {
+		log.Printf("  - %s (JSON-RPC)", *jsonRPCPath)
+	}
 	log.Println("OAuth2.1 endpoint:")
 	log.Println("  - /.well-known/oauth-protected-resource")
+	if *proxyASMetadata {
+		log.Println("  - /.well-known/oauth-authorization-server (proxied)")
+	}
+	if *metricsEnabled {
+		log.Println("Metrics endpoint:")
+		log.Println("  - /metrics")
+	}
+	log.Println("Health endpoints:")
+	log.Println("  - /healthz")
+	log.Println("  - /readyz")
+	if *versionEndpoint {
+		log.Println("Version endpoint:")
+		log.Println("  - /version")
+	}
+	if *adminToken != "" {
+		log.Println("Admin endpoints:")
+		log.Println("  - /admin/diagnostics")
+		log.Println("  - /admin/maintenance")
+	}
+
+	if *warmupTimeout > 0 {
+		oauthConfig.StartWarmup(ctx, *warmupTimeout, asMetadataProxy)
+	}
+
+	var lns []net.Listener
+	if *unixSocket != "" {
+		var ln net.Listener
+		ln, err = listenUnix(*unixSocket)
+		if err == nil {
+			lns = []net.Listener{ln}
+			defer os.Remove(*unixSocket)
+		}
+	} else {
+		lns, err = listenTCP(splitList(*listen))
+	}
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+	if *unixSocket == "" && strings.Contains(*listen, ":0") {
+		// Show the ports actually chosen for :0
+		log.Printf("Listening on %s", listenerAddrs(lns))
+	}
+
+	// All listeners share one server, so a single Shutdown drains every one of them
+	srv := &http.Server{Handler: handler}
+	if certReloader != nil {
+		srv.TLSConfig = &tls.Config{GetCertificate: certReloader.GetCertificate}
+		if clientCAs != nil {
+			// Certificates are optional at the handshake; ClientCertMiddleware requires them per path
+			srv.TLSConfig.ClientCAs = clientCAs
+			srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+
+		// SIGHUP reloads the certificate, so it can be rotated without dropping connections
+		reloadSignal := make(chan os.Signal, 1)
+		signal.Notify(reloadSignal, syscall.SIGHUP)
+		go func() {
+			for range reloadSignal {
+				if err := certReloader.Reload(); err != nil {
+					logger.Warn("Keeping previous TLS certificate", "error", err)
+				}
+			}
+		}()
+	}
+	serveErr := serveListeners(srv, lns, certReloader != nil)
+
+	select {
+	case err := <-serveErr:
+		logger.Error("Server failed", "error", err)
+		return
+	case <-ctx.Done():
+	}
 
-	if err := http.ListenAndServe(":8000", mux); err != nil {
-		log.Printf("Server failed: %v", err)
+	// Drain in-flight requests before exiting; streaming sessions still open at the timeout are closed
+	log.Printf("Shutting down listeners on %s (timeout %v)", listenerAddrs(lns), *shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("Shutdown timed out, closing remaining connections", "error", err)
+		srv.Close()
 	}
+	log.Printf("Shutdown complete")
 }
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/auth"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// toolCall builds a tool call request carrying the token scopes and claims
+func toolCall(scopes []string, claims map[string]any) *mcp.CallToolRequest {
+	return &mcp.CallToolRequest{Extra: &mcp.RequestExtra{TokenInfo: &auth.TokenInfo{Scopes: scopes, Extra: claims}}}
+}
+
+func TestServeListenersOnMultipleAddresses(t *testing.T) {
+	lns, err := listenTCP([]string{"127.0.0.1:0", "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("listenTCP: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", HandleHealthz)
+	srv := &http.Server{Handler: mux}
+	serveErr := serveListeners(srv, lns, false)
+
+	for _, ln := range lns {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/healthz")
+		if err != nil {
+			t.Fatalf("GET /healthz on %s: %v", ln.Addr(), err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET /healthz on %s: status = %d, want %d", ln.Addr(), resp.StatusCode, http.StatusOK)
+		}
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	for range lns {
+		if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("Serve returned %v, want %v", err, http.ErrServerClosed)
+		}
+	}
+}
+
+func TestListenTCPClosesListenersOnFailure(t *testing.T) {
+	lns, err := listenTCP([]string{"127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("listenTCP: %v", err)
+	}
+	defer lns[0].Close()
+
+	// The second address is already taken, so the first listener must be released again
+	if _, err := listenTCP([]string{"127.0.0.1:0", lns[0].Addr().String()}); err == nil {
+		t.Fatal("listenTCP on a busy address succeeded")
+	}
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mcp.sock")
+
+	// A socket file left by a previous run is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := listenUnix(path)
+	if err != nil {
+		t.Fatalf("listenUnix over a stale socket: %v", err)
+	}
+	if fi, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0o660 {
+		t.Errorf("socket mode = %v, want %v", fi.Mode().Perm(), os.FileMode(0o660))
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", HandleHealthz)
+	srv := &http.Server{Handler: mux}
+	serveErr := serveListeners(srv, []net.Listener{ln}, false)
+	defer func() {
+		srv.Shutdown(context.Background())
+		<-serveErr
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://unix/healthz")
+	if err != nil {
+		t.Fatalf("GET /healthz over the Unix socket: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /healthz over the Unix socket: status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestListenUnixRefusesNonSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mcp.sock")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if ln, err := listenUnix(path); err == nil {
+		ln.Close()
+		t.Fatal("listenUnix replaced a regular file")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("regular file was removed: %v", err)
+	}
+}
+
+func TestToolCallDenialAudience(t *testing.T) {
+	toolAudiences = map[string][]string{"admin": {"https://admin.example"}}
+	t.Cleanup(func() { toolAudiences = nil })
+
+	tests := []struct {
+		name   string
+		tool   string
+		aud    any
+		denied bool
+	}{
+		{"tool audience included", "admin", []any{"http://localhost:8000", "https://admin.example"}, false},
+		{"tool audience in canonical form", "admin", []any{"http://localhost:8000", "HTTPS://admin.example/"}, false},
+		{"tool audience missing", "admin", "http://localhost:8000", true},
+		{"no audience", "admin", nil, true},
+		{"tool without audience requirement", "echo", "http://localhost:8000", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			denial := toolCallDenial(tt.tool, nil, false, toolCall(nil, map[string]any{"aud": tt.aud}))
+			if tt.denied != (denial != "") {
+				t.Fatalf("toolCallDenial = %q, want denied=%v", denial, tt.denied)
+			}
+			if tt.denied && !strings.HasPrefix(denial, "invalid_token") {
+				t.Errorf("denial %q does not report invalid_token", denial)
+			}
+		})
+	}
+}
+
+// bearerTransport adds the bearer token to each request
+type bearerTransport struct {
+	token string
+}
+
+func (t bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// connect opens a client session to server over the transport
+func connect(t *testing.T, transport mcp.Transport) *mcp.ClientSession {
+	t.Helper()
+	session, err := mcp.NewClient(&mcp.Implementation{Name: "test", Version: "v0.0.1"}, nil).Connect(context.Background(), transport, nil)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() { session.Close() })
+	return session
+}
+
+// connectHTTP opens a client session over streamable HTTP with a token whose scopes are the comma-separated token itself
+func connectHTTP(t *testing.T, token string) *mcp.ClientSession {
+	t.Helper()
+	verifier := func(ctx context.Context, token string, r *http.Request) (*auth.TokenInfo, error) {
+		return &auth.TokenInfo{Scopes: strings.Split(token, ","), Expiration: time.Now().Add(time.Hour), Extra: map[string]any{"sub": "alice"}}, nil
+	}
+	handler := mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return newServer(nil, false) }, nil)
+	srv := httptest.NewServer(auth.RequireBearerToken(verifier, nil)(handler))
+	t.Cleanup(srv.Close)
+	return connect(t, &mcp.StreamableClientTransport{Endpoint: srv.URL, HTTPClient: &http.Client{Transport: bearerTransport{token}}})
+}
+
+// callEcho calls the echo tool, returning the text of the result and whether it is an error
+func callEcho(t *testing.T, session *mcp.ClientSession) (string, bool) {
+	t.Helper()
+	res, err := session.CallTool(context.Background(), &mcp.CallToolParams{Name: "echo", Arguments: map[string]any{"message": "hello"}})
+	if err != nil {
+		t.Fatalf("CallTool: %v", err)
+	}
+	var text string
+	if len(res.Content) > 0 {
+		if content, ok := res.Content[0].(*mcp.TextContent); ok {
+			text = content.Text
+		}
+	}
+	return text, res.IsError
+}
+
+func TestToolScopes(t *testing.T) {
+	if text, isError := callEcho(t, connectHTTP(t, "openid,mcp:tools")); isError {
+		t.Errorf("echo with the mcp:tools scope failed: %s", text)
+	}
+
+	// Lacking the scope is reported by the tool result rather than the transport
+	text, isError := callEcho(t, connectHTTP(t, "openid"))
+	if !isError || !strings.Contains(text, "requires the mcp:tools scope") {
+		t.Errorf("echo without the mcp:tools scope: result = %q (error %v), want a missing scope error", text, isError)
+	}
+}
+
+func TestBase64Tool(t *testing.T) {
+	session := connectHTTP(t, "mcp:tools")
+	tests := []struct {
+		name    string
+		mode    string
+		data    string
+		want    string
+		isError bool
+	}{
+		{"encode", "encode", "hello, world", "aGVsbG8sIHdvcmxk", false},
+		{"valid decode", "decode", "aGVsbG8sIHdvcmxk", "hello, world", false},
+		{"invalid decode", "decode", "not base64!", "Invalid base64 input", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := session.CallTool(context.Background(), &mcp.CallToolParams{Name: "base64", Arguments: map[string]any{"mode": tt.mode, "data": tt.data}})
+			if err != nil {
+				t.Fatalf("CallTool: %v", err)
+			}
+			text := res.Content[0].(*mcp.TextContent).Text
+			if res.IsError != tt.isError || !strings.HasPrefix(text, tt.want) {
+				t.Errorf("result = %q (error %v), want %q (error %v)", text, res.IsError, tt.want, tt.isError)
+			}
+		})
+	}
+}
+
+func TestWhoamiOptionalClaimsScope(t *testing.T) {
+	for _, withScope := range []bool{false, true} {
+		token := "mcp:tools"
+		if withScope {
+			token += "," + whoamiClaimsScope
+		}
+		res, err := connectHTTP(t, token).CallTool(context.Background(), &mcp.CallToolParams{Name: "whoami", Arguments: map[string]any{"format": "json"}})
+		if err != nil {
+			t.Fatalf("CallTool: %v", err)
+		}
+		result, _ := res.StructuredContent.(map[string]any)
+		if result["subject"] != "alice" {
+			t.Errorf("whoami = %v, want subject alice", res.StructuredContent)
+		}
+		// The full claims are only included with the optional scope
+		if _, hasClaims := result["claims"]; hasClaims != withScope {
+			t.Errorf("with %s=%v: whoami = %v, claims included: %v", whoamiClaimsScope, withScope, res.StructuredContent, hasClaims)
+		}
+	}
+}
+
+func TestLogToolInventory(t *testing.T) {
+	newServer(nil, false)
+	logs := captureLogs(t)
+	logToolInventory([]string{"openid"})
+
+	entries := make(map[string]map[string]any)
+	for line := range strings.SplitSeq(logs.String(), "\n") {
+		var entry map[string]any
+		if json.Unmarshal([]byte(line), &entry) == nil && entry["msg"] == "Registered tool" {
+			entries[entry["name"].(string)] = entry
+		}
+	}
+	if len(entries) != len(registeredTools) {
+		t.Errorf("%d registration entries, want one per each of the %d tools:\n%s", len(entries), len(registeredTools), logs)
+	}
+	for _, tool := range registeredTools {
+		entry, ok := entries[tool.Name]
+		if !ok {
+			t.Errorf("no registration entry for %s", tool.Name)
+			continue
+		}
+		// The scopes of every request come first, then the tool's own
+		wantScopes := fmt.Sprint(append([]string{"openid"}, toolScopes[tool.Name]...))
+		if entry["enabled"] != true || entry["description"] != tool.Description || fmt.Sprint(entry["required_scopes"]) != wantScopes {
+			t.Errorf("%s: entry = %v, want it enabled with its description and required scopes", tool.Name, entry)
+		}
+	}
+}
+
+func TestWhoamiFormats(t *testing.T) {
+	session := connectHTTP(t, "openid,mcp:tools")
+	whoami := func(format string) *mcp.CallToolResult {
+		t.Helper()
+		args := map[string]any{}
+		if format != "" {
+			args["format"] = format
+		}
+		res, err := session.CallTool(context.Background(), &mcp.CallToolParams{Name: "whoami", Arguments: args})
+		if err != nil {
+			t.Fatalf("CallTool: %v", err)
+		}
+		return res
+	}
+
+	// Human-readable text by default
+	for _, format := range []string{"", "text"} {
+		res := whoami(format)
+		if text := res.Content[0].(*mcp.TextContent).Text; res.IsError || text != "Subject: alice\nScopes: openid mcp:tools" || res.StructuredContent != nil {
+			t.Errorf("format %q: result = %q (error %v, structured %v), want the text output", format, text, res.IsError, res.StructuredContent)
+		}
+	}
+
+	// Machine JSON as both the text and the structured content
+	res := whoami("json")
+	var parsed map[string]any
+	if err := json.Unmarshal([]byte(res.Content[0].(*mcp.TextContent).Text), &parsed); err != nil {
+		t.Fatalf("json format: invalid JSON text content: %v", err)
+	}
+	want := map[string]any{"subject": "alice", "scopes": []any{"openid", "mcp:tools"}}
+	if res.IsError || fmt.Sprint(parsed) != fmt.Sprint(want) || fmt.Sprint(res.StructuredContent) != fmt.Sprint(want) {
+		t.Errorf("json format: text %v, structured %v, want %v", parsed, res.StructuredContent, want)
+	}
+
+	// The schema limits the format to the supported ones
+	if _, err := session.CallTool(context.Background(), &mcp.CallToolParams{Name: "whoami", Arguments: map[string]any{"format": "xml"}}); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestStaticContextValues(t *testing.T) {
+	for _, values := range []map[string]string{{"tenant": "acme", "env": "prod"}, nil} {
+		server := newServer(values, true)
+		// The probe tool reports the static values it finds in its context
+		server.AddTool(&mcp.Tool{Name: "probe", InputSchema: map[string]any{"type": "object"}}, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			tenant, ok := StaticContextValue(ctx, "tenant")
+			text := fmt.Sprintf("tenant=%s ok=%v all=%v", tenant, ok, StaticContextValues(ctx))
+			return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: text}}}, nil
+		})
+		clientTransport, serverTransport := mcp.NewInMemoryTransports()
+		if _, err := server.Connect(context.Background(), serverTransport, nil); err != nil {
+			t.Fatalf("server Connect: %v", err)
+		}
+		res, err := connect(t, clientTransport).CallTool(context.Background(), &mcp.CallToolParams{Name: "probe"})
+		if err != nil {
+			t.Fatalf("CallTool: %v", err)
+		}
+		want := "tenant= ok=false all=map[]"
+		if values != nil {
+			want = "tenant=acme ok=true all=map[env:prod tenant:acme]"
+		}
+		if text := res.Content[0].(*mcp.TextContent).Text; text != want {
+			t.Errorf("values %v: tool context = %q, want %q", values, text, want)
+		}
+	}
+}
+
+func TestToolCallWithoutTokenInfo(t *testing.T) {
+	for _, stdio := range []bool{false, true} {
+		clientTransport, serverTransport := mcp.NewInMemoryTransports()
+		if _, err := newServer(nil, stdio).Connect(context.Background(), serverTransport, nil); err != nil {
+			t.Fatalf("server Connect: %v", err)
+		}
+		text, isError := callEcho(t, connect(t, clientTransport))
+		// Only stdio, which has no token, may skip the checks; elsewhere a missing token must not go unchecked
+		if isError == stdio {
+			t.Errorf("stdio=%v: result = %q (error %v)", stdio, text, isError)
+		}
+	}
+}
+
+func TestTransportsReachSameTool(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	token := key.mint(t, validClaims())
+
+	// The SSE and JSON-RPC transports are mounted as main does, behind the same authorization
+	sseServer := func(r *http.Request) *mcp.Server {
+		s := newServer(nil, false)
+		s.AddReceivingMiddleware(SessionTokenMiddleware(auth.TokenInfoFromContext(r.Context())))
+		return s
+	}
+	jsonHandler := mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return newServer(nil, false) }, &mcp.StreamableHTTPOptions{
+		Stateless:    true,
+		JSONResponse: true,
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/sse", c.OAuthMiddleware(mcp.NewSSEHandler(sseServer, nil)))
+	mux.Handle("/rpc", c.OAuthMiddleware(jsonHandler))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	client := &http.Client{Transport: bearerTransport{token}}
+	transports := map[string]mcp.Transport{
+		"sse":      &mcp.SSEClientTransport{Endpoint: srv.URL + "/sse", HTTPClient: client},
+		"json-rpc": &mcp.StreamableClientTransport{Endpoint: srv.URL + "/rpc", HTTPClient: client},
+	}
+	for name, transport := range transports {
+		// Both reach the echo tool as the token's subject
+		if text, isError := callEcho(t, connect(t, transport)); isError || text != "Echo (alice): hello" {
+			t.Errorf("%s: result = %q (error %v), want %q", name, text, isError, "Echo (alice): hello")
+		}
+	}
+
+	// Opening an SSE stream requires a valid token as well
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sse", nil))
+	assertAuthError(t, rec, http.StatusUnauthorized, "")
+	req := httptest.NewRequest(http.MethodGet, "/sse", nil)
+	req.Header.Set("Authorization", "Bearer "+newTestKey(t).mint(t, validClaims()))
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+}
+
+func TestEnvOr(t *testing.T) {
+	tests := []struct {
+		name      string
+		flagValue string
+		env       *string
+		want      string
+	}{
+		{"flag wins over env", "https://flag.example", ptr("https://env.example"), "https://flag.example"},
+		{"env wins over default", "https://default.example", ptr("https://env.example"), "https://env.example"},
+		{"empty env wins over default", "https://default.example", ptr(""), ""},
+		{"default without env", "https://default.example", nil, "https://default.example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != nil {
+				t.Setenv("MCP_TEST_URL", *tt.env)
+			}
+			if got := envOr(tt.flagValue, "https://default.example", "MCP_TEST_URL"); got != tt.want {
+				t.Errorf("envOr = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// ptr returns a pointer to s
+func ptr(s string) *string {
+	return &s
+}
+
+func TestResolveListenAddr(t *testing.T) {
+	tests := []struct {
+		name       string
+		listen     string
+		unixSocket string
+		env        *string
+		want       string
+		wantErr    bool
+	}{
+		{"default", defaultListenAddr, "", nil, defaultListenAddr, false},
+		{"env", defaultListenAddr, "", ptr("127.0.0.1:9000"), "127.0.0.1:9000", false},
+		{"flag over env", "127.0.0.1:8080", "", ptr("127.0.0.1:9000"), "127.0.0.1:8080", false},
+		{"unix socket ignores env", defaultListenAddr, "/tmp/mcp.sock", ptr("127.0.0.1:9000"), defaultListenAddr, false},
+		{"unix socket with -listen", "127.0.0.1:8080", "/tmp/mcp.sock", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != nil {
+				t.Setenv("MCP_LISTEN_ADDR", *tt.env)
+			}
+			got, err := resolveListenAddr(tt.listen, tt.unixSocket)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveListenAddr error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveListenAddr = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRegistry holds the metrics served at /metrics
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	httpRequestsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "mcp_http_requests_total",
+		Help: "MCP HTTP requests by route and status.",
+	}, []string{"path", "status"})
+	httpRequestDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mcp_http_request_duration_seconds",
+		Help:    "MCP HTTP request latency by route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"path", "status"})
+	authTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "mcp_auth_total",
+		Help: "Authorization outcomes: success, missing_token, invalid_token, insufficient_scope or unavailable (introspection circuit open).",
+	}, []string{"outcome"})
+	deprecatedKIDTokensTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "mcp_deprecated_kid_tokens_total",
+		Help: "Accepted signatures by a deprecated key ID (-deprecated-kids), by kid.",
+	}, []string{"kid"})
+	authDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mcp_auth_duration_seconds",
+		Help:    "Time OAuthMiddleware spends authorizing a request (token parsing, validation, JWKS and introspection calls) by outcome.",
+		Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"outcome"})
+	algNoneRejectedTotal = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "mcp_alg_none_rejected_total",
+		Help: "Tokens rejected for being unsigned (alg=none).",
+	})
+	toolCallsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "mcp_tool_calls_total",
+		Help: "Tool invocations by tool name.",
+	}, []string{"tool"})
+)
+
+// MetricsHandler serves the metrics in the Prometheus exposition format, with Go runtime and process metrics
+func MetricsHandler() http.Handler {
+	metricsRegistry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// MetricsMiddleware records the request count and latency of each request.
+// Requests are labelled by the matched route pattern rather than the raw path to keep cardinality bounded.
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		labels := prometheus.Labels{"path": r.Pattern, "status": strconv.Itoa(rec.status)}
+		httpRequestsTotal.With(labels).Inc()
+		httpRequestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// authOutcome returns the mcp_auth_total label for a rejected request
+func (k authErrorKind) authOutcome() string {
+	if code := k.code(); code != "" {
+		return code
+	}
+	return "missing_token"
+}
+
+// authStartKey is the context key for when OAuthMiddleware started authorizing the request
+type authStartKey struct{}
+
+// observeAuthDuration records the time OAuthMiddleware took to reach its decision for the request
+func observeAuthDuration(r *http.Request, outcome string) {
+	if start, ok := r.Context().Value(authStartKey{}).(time.Time); ok {
+		authDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// GatewaySecretMiddleware rejects requests that do not carry the shared secret header injected by the API gateway
+func GatewaySecretMiddleware(header, secret string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Constant-time compare to avoid leaking the secret through timing
+		value := r.Header.Get(header)
+		if subtle.ConstantTimeCompare([]byte(value), []byte(secret)) != 1 {
+			logger.Warn("Rejected request without valid gateway secret", "header", header, "remote_addr", r.RemoteAddr)
+			recordDecision(r, "gateway", "deny")
+			writeError(w, http.StatusForbidden, "missing or invalid gateway secret")
+			return
+		}
+
+		recordDecision(r, "gateway", "pass")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HostAllowlistMiddleware rejects requests whose Host header is not in the allowlist.
+// Entries may include a port ("example.com:8000") or match any port ("example.com").
+func HostAllowlistMiddleware(allowed []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.ToLower(r.Host)
+		hostname := host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			hostname = h
+		}
+		if !slices.Contains(allowed, host) && !slices.Contains(allowed, hostname) {
+			logger.Warn("Rejected request with disallowed Host", "host", r.Host, "remote_addr", r.RemoteAddr)
+			recordDecision(r, "hosts", "deny")
+			writeError(w, http.StatusBadRequest, "host not allowed")
+			return
+		}
+
+		recordDecision(r, "hosts", "pass")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SNIMiddleware rejects TLS requests whose SNI server name is not the resource host with 421 Misdirected Request,
+// so tokens bound to the resource are only used over its own TLS endpoint
+func SNIMiddleware(host string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || !strings.EqualFold(r.TLS.ServerName, host) {
+			serverName := ""
+			if r.TLS != nil {
+				serverName = r.TLS.ServerName
+			}
+			logger.Warn("Rejected request with mismatched TLS server name", "server_name", serverName, "expected", host, "remote_addr", r.RemoteAddr)
+			recordDecision(r, "sni", "deny")
+			writeError(w, http.StatusMisdirectedRequest, "TLS server name does not match this resource")
+			return
+		}
+
+		recordDecision(r, "sni", "pass")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientCertMiddleware rejects requests under the path prefixes with 403 unless the client presented a certificate
+// that verified against the client CAs, so e.g. admin endpoints need mTLS while MCP clients use bearer tokens only.
+// The TLS server must request certificates with tls.VerifyClientCertIfGiven.
+func ClientCertMiddleware(prefixes []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !slices.ContainsFunc(prefixes, func(prefix string) bool { return pathHasPrefix(r.URL.Path, prefix) }) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			logger.Warn("Rejected request without a verified client certificate", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			recordDecision(r, "client-cert", "deny")
+			writeError(w, http.StatusForbidden, "client certificate required")
+			return
+		}
+
+		recordDecision(r, "client-cert", "pass")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// pathHasPrefix reports whether path is prefix or lies below it, so /admin does not match /administrator
+func pathHasPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// singleValueHeaders are security-relevant headers that must appear at most once
+var singleValueHeaders = []string{"Authorization", "Mcp-Session-Id", "Mcp-Protocol-Version", "X-Forwarded-Host", "X-Forwarded-Proto"}
+
+// HeaderInjectionMiddleware rejects requests with CR, LF or NUL in a header, or with a duplicated
+// security-relevant header, which proxies and this server could otherwise interpret differently
+func HeaderInjectionMiddleware(securityLog *SecurityLogger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reason := suspiciousHeader(r.Header); reason != "" {
+			logger.Warn("Rejected request with suspicious headers", "reason", reason, "remote_addr", r.RemoteAddr)
+			if securityLog != nil {
+				securityLog.Log(r, "deny", reason, "", "")
+			}
+			recordDecision(r, "headers", "deny")
+			writeError(w, http.StatusBadRequest, "malformed request headers")
+			return
+		}
+
+		recordDecision(r, "headers", "pass")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// suspiciousHeader describes the first header injection found, or returns "" when the headers are clean
+func suspiciousHeader(header http.Header) string {
+	for name, values := range header {
+		if strings.ContainsAny(name, "\r\n\x00") {
+			return fmt.Sprintf("control characters in header name %q", name)
+		}
+		for _, v := range values {
+			if strings.ContainsAny(v, "\r\n\x00") {
+				return fmt.Sprintf("control characters in header %s", name)
+			}
+		}
+	}
+	for _, name := range singleValueHeaders {
+		if len(header.Values(name)) > 1 {
+			return fmt.Sprintf("duplicate %s header", name)
+		}
+	}
+	return ""
+}
+
+// CORSMiddleware adds CORS headers for browser-based clients and answers preflight requests.
+// With "*" in allowed any origin is accepted; otherwise the request's Origin is echoed back only when listed.
+func CORSMiddleware(allowed []string, next http.Handler) http.Handler {
+	wildcard := slices.Contains(allowed, "*")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		switch {
+		case wildcard:
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		case slices.Contains(allowed, origin):
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		default:
+			w.Header().Add("Vary", "Origin")
+			if preflight {
+				logger.Warn("Rejected CORS preflight from disallowed origin", "origin", origin)
+				writeError(w, http.StatusForbidden, "origin not allowed")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Clients need the session ID and the auth challenge from responses
+		w.Header().Set("Access-Control-Expose-Headers", "Mcp-Session-Id, WWW-Authenticate")
+
+		if preflight {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Mcp-Session-Id, Mcp-Protocol-Version, Last-Event-ID")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LandingPageMiddleware serves a short HTML page to browsers visiting the root without credentials.
+// MCP requests (POST, or any request with an Authorization header) still go through next.
+func LandingPageMiddleware(metadataURL string, next http.Handler) http.Handler {
+	page := fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head><title>MCP Server</title></head>
+<body>
+<h1>MCP Server</h1>
+<p>This is an OAuth-protected Model Context Protocol server. Connect to it with an MCP client.</p>
+<p>Protected resource metadata: <a href="%[1]s">%[1]s</a></p>
+</body>
+</html>
+`, html.EscapeString(metadataURL))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.Path == "/" && r.Header.Get("Authorization") == "" &&
+			strings.Contains(r.Header.Get("Accept"), "text/html") {
+			recordDecision(r, "landing", "served")
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			io.WriteString(w, page)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireProtocolVersionMiddleware rejects MCP requests lacking the MCP-Protocol-Version header.
+// The initialize request is exempt because the version is only negotiated by it.
+func RequireProtocolVersionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("MCP-Protocol-Version") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == "POST" && r.Body != nil {
+			bodyBytes, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "failed to read body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+			var msg struct {
+				Method string `json:"method"`
+			}
+			if json.Unmarshal(bodyBytes, &msg) == nil && msg.Method == "initialize" {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		logger.Warn("Rejected request without MCP-Protocol-Version header", "method", r.Method, "path", r.URL.Path)
+		recordDecision(r, "protocol-version", "deny")
+		writeError(w, http.StatusBadRequest, "missing MCP-Protocol-Version header")
+	})
+}
+
+// maintenanceMode is set while the server is out of rotation for planned maintenance
+var maintenanceMode atomic.Bool
+
+// setMaintenanceMode switches maintenance mode on or off and logs the change
+func setMaintenanceMode(enabled bool) {
+	if maintenanceMode.Swap(enabled) == enabled {
+		return
+	}
+	if enabled {
+		logger.Info("Maintenance mode enabled: MCP requests will get 503")
+	} else {
+		logger.Info("Maintenance mode disabled")
+	}
+}
+
+// MaintenanceMiddleware rejects MCP requests with 503 while maintenance mode is on
+func MaintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maintenanceMode.Load() {
+			recordDecision(r, "maintenance", "deny")
+			w.Header().Set("Retry-After", "120")
+			writeError(w, http.StatusServiceUnavailable, "server is under maintenance, retry later")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// errorFormat selects how error responses are written: "jsonrpc" (default) or "problem" (RFC 9457)
+var errorFormat = "jsonrpc"
+
+// writeError writes an HTTP error response in the configured error format
+func writeError(w http.ResponseWriter, status int, detail string) {
+	if errorFormat == "problem" {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]any{
+			"type":   "about:blank",
+			"title":  http.StatusText(status),
+			"status": status,
+			"detail": detail,
+		})
+		return
+	}
+
+	code := -32600 // Invalid Request
+	if status >= 500 {
+		code = -32603 // Internal error
+	}
+	writeJSONRPCError(w, status, code, detail)
+}
+
+// writeJSONRPCError writes a JSON-RPC error response that is not tied to a request id
+func writeJSONRPCError(w http.ResponseWriter, status int, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      nil,
+		"error": map[string]any{
+			"code":    code,
+			"message": message,
+		},
+	})
+}
+
+// SessionLimiter limits the number of concurrent streaming sessions per subject
+type SessionLimiter struct {
+	max     int
+	mu      sync.Mutex
+	active  map[string]map[string]int // subject -> session id -> open streams
+	streams atomic.Uint64             // numbers the streams that carry no session id
+}
+
+// NewSessionLimiter creates a limiter allowing max concurrent streaming sessions per subject
+func NewSessionLimiter(max int) *SessionLimiter {
+	return &SessionLimiter{max: max, active: make(map[string]map[string]int)}
+}
+
+// Middleware rejects new streaming (GET) sessions beyond the limit with 429; existing sessions continue.
+// A stream without an Mcp-Session-Id (e.g. a legacy SSE stream) counts as a session of its own.
+// It must run after OAuthMiddleware so the subject is available.
+func (l *SessionLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := ClaimsFromContext(r.Context())
+		if r.Method != "GET" || !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sub, _ := claims["sub"].(string)
+		sessionID := r.Header.Get("Mcp-Session-Id")
+		if sessionID == "" {
+			// The NUL prefix keeps these keys apart from any session id a client can send
+			sessionID = fmt.Sprintf("\x00stream-%d", l.streams.Add(1))
+		}
+
+		if !l.acquire(sub, sessionID) {
+			logger.Warn("Rejected streaming session: too many concurrent sessions for subject", "max", l.max)
+			recordDecision(r, "session-limit", "deny")
+			w.Header().Set("Retry-After", "60")
+			writeError(w, http.StatusTooManyRequests, "too many concurrent streaming sessions")
+			return
+		}
+		defer l.release(sub, sessionID)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire registers a stream for the session, refusing new sessions once the subject is at the limit
+func (l *SessionLimiter) acquire(sub, sessionID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sessions := l.active[sub]
+	if sessions == nil {
+		sessions = make(map[string]int)
+		l.active[sub] = sessions
+	}
+	if sessions[sessionID] == 0 && len(sessions) >= l.max {
+		return false
+	}
+	sessions[sessionID]++
+	return true
+}
+
+// release unregisters a stream for the session
+func (l *SessionLimiter) release(sub, sessionID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sessions := l.active[sub]
+	if sessions[sessionID]--; sessions[sessionID] <= 0 {
+		delete(sessions, sessionID)
+	}
+	if len(sessions) == 0 {
+		delete(l.active, sub)
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSNIMiddleware(t *testing.T) {
+	handler := SNIMiddleware("mcp.example.com", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		name string
+		tls  *tls.ConnectionState
+		want int
+	}{
+		{"matching server name", &tls.ConnectionState{ServerName: "mcp.example.com"}, http.StatusOK},
+		{"matching server name in another case", &tls.ConnectionState{ServerName: "MCP.example.com"}, http.StatusOK},
+		{"mismatching server name", &tls.ConnectionState{ServerName: "other.example.com"}, http.StatusMisdirectedRequest},
+		{"no server name", &tls.ConnectionState{}, http.StatusMisdirectedRequest},
+		{"plain HTTP", nil, http.StatusMisdirectedRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.TLS = tt.tls
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestGatewaySecretMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		absent bool
+		want   int
+	}{
+		{"matching secret", "s3cret", false, http.StatusOK},
+		{"mismatching secret", "wrong", false, http.StatusForbidden},
+		{"secret prefix", "s3cre", false, http.StatusForbidden},
+		{"missing header", "", true, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached := false
+			handler := GatewaySecretMiddleware("X-Gateway-Secret", "s3cret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			if !tt.absent {
+				req.Header.Set("X-Gateway-Secret", tt.value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if reached != (tt.want == http.StatusOK) {
+				t.Errorf("handler reached = %v, want %v", reached, tt.want == http.StatusOK)
+			}
+		})
+	}
+}
+
+// streamOpener opens GET streams through the handler that stay open until closed
+type streamOpener struct {
+	handler http.Handler
+	opened  chan struct{}
+}
+
+// newStreamOpener returns an opener of streams through limiter, whose handler holds each stream open
+func newStreamOpener(limiter *SessionLimiter) *streamOpener {
+	o := &streamOpener{opened: make(chan struct{})}
+	o.handler = limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o.opened <- struct{}{}
+		<-r.Context().Done()
+	}))
+	return o
+}
+
+// open starts a stream by sub for the session ("" sends no Mcp-Session-Id, as an SSE stream does), returning its
+// status once it is open or rejected, and the function closing it
+func (o *streamOpener) open(t *testing.T, sub, sessionID string) (int, func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), claimsContextKey{}, jwt.MapClaims{"sub": sub}))
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	if sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", sessionID)
+	}
+	status := make(chan int, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		o.handler.ServeHTTP(rec, req)
+		status <- rec.Code
+	}()
+	select {
+	case <-o.opened:
+		closed := func() {
+			cancel()
+			<-status
+		}
+		t.Cleanup(cancel)
+		return http.StatusOK, closed
+	case code := <-status:
+		cancel()
+		return code, func() {}
+	}
+}
+
+func TestSessionLimiter(t *testing.T) {
+	o := newStreamOpener(NewSessionLimiter(2))
+	for i, tt := range []struct {
+		sessionID string
+		want      int
+	}{
+		{"a", http.StatusOK},
+		{"b", http.StatusOK},
+		// Another stream of an existing session continues
+		{"a", http.StatusOK},
+		{"c", http.StatusTooManyRequests},
+	} {
+		if got, _ := o.open(t, "alice", tt.sessionID); got != tt.want {
+			t.Errorf("stream %d (session %s): status = %d, want %d", i+1, tt.sessionID, got, tt.want)
+		}
+	}
+	// The limit is per subject
+	if got, _ := o.open(t, "bob", "d"); got != http.StatusOK {
+		t.Errorf("first session of another subject: status = %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestSessionLimiterSSEStreams(t *testing.T) {
+	o := newStreamOpener(NewSessionLimiter(2))
+	_, closeFirst := o.open(t, "alice", "")
+	if got, _ := o.open(t, "alice", ""); got != http.StatusOK {
+		t.Fatalf("second SSE stream: status = %d, want %d", got, http.StatusOK)
+	}
+	if got, _ := o.open(t, "alice", ""); got != http.StatusTooManyRequests {
+		t.Fatalf("SSE stream beyond the limit: status = %d, want %d", got, http.StatusTooManyRequests)
+	}
+
+	// Closing a stream frees its slot
+	closeFirst()
+	if got, _ := o.open(t, "alice", ""); got != http.StatusOK {
+		t.Errorf("SSE stream after one closed: status = %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestRequireProtocolVersionMiddleware(t *testing.T) {
+	const toolsList = `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`
+	tests := []struct {
+		name    string
+		require bool
+		version string
+		body    string
+		want    int
+	}{
+		{"header present", true, "2025-06-18", toolsList, http.StatusOK},
+		{"header absent", true, "", toolsList, http.StatusBadRequest},
+		{"header absent on initialize", true, "", `{"jsonrpc":"2.0","id":1,"method":"initialize"}`, http.StatusOK},
+		{"header absent with the flag off", false, "", toolsList, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached := false
+			var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+				// The body is still readable after the initialize check
+				if body, _ := io.ReadAll(r.Body); string(body) != tt.body {
+					t.Errorf("handler read %q, want %q", body, tt.body)
+				}
+			})
+			if tt.require {
+				handler = RequireProtocolVersionMiddleware(handler)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.version != "" {
+				req.Header.Set("MCP-Protocol-Version", tt.version)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if reached != (tt.want == http.StatusOK) {
+				t.Errorf("handler reached = %v, want %v", reached, tt.want == http.StatusOK)
+			}
+			if tt.want == http.StatusBadRequest && rec.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("Content-Type = %q, want a JSON error", rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestHostAllowlistMiddleware(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	token := key.mint(t, validClaims())
+	tests := []struct {
+		name    string
+		allowed []string
+		host    string
+		token   string
+		want    int
+	}{
+		{"allowed host", []string{"mcp.example.com"}, "mcp.example.com", token, http.StatusOK},
+		{"allowed host with a port", []string{"mcp.example.com"}, "MCP.example.com:8000", token, http.StatusOK},
+		{"allowed host and port", []string{"localhost:8000"}, "localhost:8000", token, http.StatusOK},
+		{"disallowed host", []string{"mcp.example.com"}, "attacker.example.com", token, http.StatusBadRequest},
+		// The host is checked before the token, so no authentication challenge is disclosed
+		{"disallowed host without a token", []string{"mcp.example.com"}, "attacker.example.com", "", http.StatusBadRequest},
+		{"flag off", nil, "attacker.example.com", token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := c.OAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			if len(tt.allowed) > 0 {
+				handler = HostAllowlistMiddleware(tt.allowed, handler)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.Host = tt.host
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestLandingPageMiddleware(t *testing.T) {
+	c := newTestOAuthConfig(t, newTestKey(t))
+	metadataURL := testResource + "/.well-known/oauth-protected-resource"
+	handler := LandingPageMiddleware(metadataURL, c.OAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	// A browser visiting the root gets the page linking to the metadata
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("browser GET: status = %d, Content-Type = %q, want the HTML page", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), `href="`+metadataURL+`"`) {
+		t.Errorf("landing page does not link to the metadata:\n%s", rec.Body.String())
+	}
+
+	// MCP calls still require authorization: POSTs, and GETs carrying a token
+	tests := []struct {
+		method        string
+		authorization string
+	}{
+		{http.MethodPost, ""},
+		{http.MethodGet, "Bearer invalid"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+		req.Header.Set("Accept", "text/html, application/json, text/event-stream")
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s with Accept text/html: status = %d, want %d", tt.method, rec.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestErrorFormat(t *testing.T) {
+	c := newTestOAuthConfig(t, newTestKey(t))
+	t.Cleanup(func() { errorFormat = "jsonrpc" })
+
+	for _, format := range []string{"jsonrpc", "problem"} {
+		errorFormat = format
+		rec, _ := authorize(c, "")
+		// The challenge is sent in either format
+		assertAuthError(t, rec, http.StatusUnauthorized, "")
+
+		var body map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: invalid error body %q: %v", format, rec.Body.String(), err)
+		}
+		contentType := rec.Header().Get("Content-Type")
+		switch format {
+		case "jsonrpc":
+			if contentType != "application/json" || body["jsonrpc"] != "2.0" || body["error"] == nil {
+				t.Errorf("jsonrpc: Content-Type = %q, body = %v, want a JSON-RPC error", contentType, body)
+			}
+		case "problem":
+			if contentType != "application/problem+json" {
+				t.Errorf("problem: Content-Type = %q, want application/problem+json", contentType)
+			}
+			want := map[string]any{"type": "about:blank", "title": "Unauthorized", "status": float64(http.StatusUnauthorized), "detail": "unauthorized"}
+			if !maps.Equal(body, want) {
+				t.Errorf("problem: body = %v, want %v", body, want)
+			}
+		}
+	}
+}
+
+func TestHeaderInjectionMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"clean headers", http.Header{"Authorization": {"Bearer token"}, "X-Request-Id": {"req-1"}}, http.StatusOK},
+		{"CR/LF in a header", http.Header{"X-Request-Id": {"req-1\r\nAuthorization: Bearer injected"}}, http.StatusBadRequest},
+		{"LF in the Authorization header", http.Header{"Authorization": {"Bearer token\nX-Admin: true"}}, http.StatusBadRequest},
+		{"duplicate Authorization", http.Header{"Authorization": {"Bearer token-a", "Bearer token-b"}}, http.StatusBadRequest},
+		{"repeated unrelated header", http.Header{"Accept": {"application/json", "text/event-stream"}}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var securityLog strings.Builder
+			reached := false
+			handler := HeaderInjectionMiddleware(NewSecurityLogger(&securityLog), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.Header = tt.header
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			rejected := tt.want == http.StatusBadRequest
+			if reached == rejected {
+				t.Errorf("handler reached = %v, want %v", reached, !rejected)
+			}
+			// Rejections are recorded in the security log
+			if logged := strings.Contains(securityLog.String(), `"decision":"deny"`); logged != rejected {
+				t.Errorf("security log = %q, want a deny record: %v", securityLog.String(), rejected)
+			}
+		})
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	token := key.mint(t, validClaims())
+	// The MCP endpoint and the metadata are mounted as main does, with CORS around the whole mux
+	newHandler := func(allowed ...string) http.Handler {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/.well-known/oauth-protected-resource", c.HandleProtectedResourceMetadata)
+		mux.Handle("/", c.OAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+		return CORSMiddleware(allowed, mux)
+	}
+	serve := func(handler http.Handler, method, target, origin, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			req.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	handler := newHandler("https://app.example.com", "https://admin.example.com")
+	// An allowed origin is echoed on the MCP endpoint, including its auth challenge, and on the metadata
+	for _, tt := range []struct{ method, target, token string }{
+		{http.MethodPost, "/", token},
+		{http.MethodPost, "/", ""},
+		{http.MethodGet, "/.well-known/oauth-protected-resource", ""},
+	} {
+		rec := serve(handler, tt.method, tt.target, "https://app.example.com", tt.token)
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+			t.Errorf("%s %s (token %v): Access-Control-Allow-Origin = %q, want the origin echoed", tt.method, tt.target, tt.token != "", got)
+		}
+		if !strings.Contains(rec.Header().Get("Access-Control-Expose-Headers"), "WWW-Authenticate") {
+			t.Errorf("%s %s: WWW-Authenticate not exposed", tt.method, tt.target)
+		}
+		if rec.Header().Get("Vary") != "Origin" {
+			t.Errorf("%s %s: Vary = %q, want Origin", tt.method, tt.target, rec.Header().Get("Vary"))
+		}
+	}
+
+	// A disallowed origin gets no CORS headers, but the request itself is still served
+	rec := serve(handler, http.MethodPost, "/", "https://evil.example.com", token)
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("disallowed origin: status = %d, Access-Control-Allow-Origin = %q, want %d without the header", rec.Code, rec.Header().Get("Access-Control-Allow-Origin"), http.StatusOK)
+	}
+
+	// Preflight on the MCP endpoint is answered before authorization
+	rec = serve(handler, http.MethodOptions, "/", "https://app.example.com", "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("preflight status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(got, "Authorization") || !strings.Contains(got, "Mcp-Session-Id") {
+		t.Errorf("Access-Control-Allow-Headers = %q, want Authorization and Mcp-Session-Id", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(got, http.MethodPost) {
+		t.Errorf("Access-Control-Allow-Methods = %q, want POST", got)
+	}
+	if rec := serve(handler, http.MethodOptions, "/", "https://evil.example.com", ""); rec.Code != http.StatusForbidden || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("preflight from a disallowed origin: status = %d, Access-Control-Allow-Origin = %q, want %d", rec.Code, rec.Header().Get("Access-Control-Allow-Origin"), http.StatusForbidden)
+	}
+
+	// The default wildcard allows any origin
+	if got := serve(newHandler("*"), http.MethodPost, "/", "https://evil.example.com", token).Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("wildcard: Access-Control-Allow-Origin = %q, want *", got)
+	}
+}
@@ -2,148 +2,850 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"maps"
+	"mime"
 	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/MicahParks/jwkset"
 	"github.com/MicahParks/keyfunc/v3"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/modelcontextprotocol/go-sdk/auth"
 	"github.com/modelcontextprotocol/go-sdk/oauthex"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
 )
 
+// errKIDNotAllowed is returned by the key lookup when the token's kid is not in AllowedKIDs
+var errKIDNotAllowed = errors.New("key ID is not allowed")
+
+// errTokenExpiredMidSession is the cancellation cause of a request whose token expired while it was being served
+var errTokenExpiredMidSession = errors.New("access token expired during session")
+
+// claimsContextKey is the context key for the validated token claims
+type claimsContextKey struct{}
+
+// scopesContextKey is the context key for the validated token scopes
+type scopesContextKey struct{}
+
+// ScopesFromContext returns the scopes of the validated token stored by OAuthMiddleware
+func ScopesFromContext(ctx context.Context) []string {
+	scopes, _ := ctx.Value(scopesContextKey{}).([]string)
+	return scopes
+}
+
+// ClaimsFromContext returns the validated token claims (including sub and scope) stored by OAuthMiddleware.
+// Tool handlers run outside the HTTP request context and read the claims from req.Extra.TokenInfo.Extra instead.
+func ClaimsFromContext(ctx context.Context) (jwt.MapClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey{}).(jwt.MapClaims)
+	return claims, ok
+}
+
 // OAuthConfig holds OAuth configuration
 type OAuthConfig struct {
 	AuthzServerURL string
 	JwksURL        string
 	ResourceURL    string
-	jwks           keyfunc.Keyfunc
+	// AuthorizationServers lists every authorization server advertised to clients.
+	// When empty, only AuthzServerURL is advertised.
+	AuthorizationServers []string
+	// SubPattern, when set, requires the sub claim to match this pattern
+	SubPattern *regexp.Regexp
+	// VerboseErrors includes the specific failure reason in error responses
+	VerboseErrors bool
+	// ClockSkew is the leeway allowed when validating exp, nbf and iat
+	ClockSkew time.Duration
+	// SessionExpiryGrace is how long a request (e.g. a streaming session) may continue after the token expires
+	SessionExpiryGrace time.Duration
+	// HashLogSubjects replaces sub values in logs with a salted hash
+	HashLogSubjects bool
+	LogSubjectSalt  string
+	// DebugAuth logs each token's decoded header and claims, with sensitive claims omitted, at debug level
+	DebugAuth bool
+	// AcceptedAudiences lists the audiences accepted for this resource (ResourceURL when empty)
+	AcceptedAudiences []string
+	// AudienceFromRequestURL validates aud against the absolute request URL instead of ResourceURL
+	AudienceFromRequestURL bool
+	// TrustProxyHeaders uses X-Forwarded-Proto/X-Forwarded-Host when reconstructing the request URL
+	TrustProxyHeaders bool
+	// AdvertisedTools lists tool names disclosed in the metadata (vendor extension); nil disables it
+	AdvertisedTools []string
+	// SecurityLog receives a record for every authorization decision; nil disables it
+	SecurityLog *SecurityLogger
+	// LazyJWKS defers creating the JWKS client until the first token needs validation
+	LazyJWKS bool
+	// ClaimHeaders maps claim names to headers set on the request passed downstream; unmapped claims are not forwarded
+	ClaimHeaders map[string]string
+	// ForwardAccessToken keeps the Authorization header on the request passed downstream
+	ForwardAccessToken bool
+	// AudiencesFile lists additional accepted audiences, one per line, reloaded when it changes
+	AudiencesFile       string
+	additionalAudiences atomic.Pointer[[]string]
+	// audiencesModTime is the modification time of the audiences file when it was last loaded
+	audiencesModTime time.Time
+	// DevToken, when set, is accepted verbatim as a bearer token with DevSubject and DevScopes (development only)
+	DevToken   string
+	DevSubject string
+	DevScopes  []string
+	// JwksUnknownKIDRefreshInterval is the minimum interval between JWKS refreshes triggered by unknown key IDs
+	JwksUnknownKIDRefreshInterval time.Duration
+	keyLookups                    singleflight.Group
+	// AllowedKIDs, when set, pins the key IDs that may sign accepted tokens
+	AllowedKIDs []string
+	// DeprecatedKIDs lists key IDs being retired; tokens they sign are accepted with a warning
+	DeprecatedKIDs []string
+	// JwksFailOpen accepts tokens without signature verification while the JWKS cannot be fetched (insecure)
+	JwksFailOpen bool
+	// ExclusiveAudience rejects tokens whose aud includes any value other than the accepted audiences
+	ExclusiveAudience bool
+	// MaxAudiences rejects tokens with more aud entries than this; disabled when 0
+	MaxAudiences int
+	// RequiredScopes lists scopes a token must all carry (or one of, with AnyRequiredScope); scope validation is skipped when empty
+	RequiredScopes []string
+	// AnyRequiredScope accepts tokens carrying at least one of RequiredScopes instead of all of them
+	AnyRequiredScope bool
+	// ScopeAudienceRules maps scope prefixes to the audience such scopes imply (e.g. "resourceX:" -> "https://x.example")
+	ScopeAudienceRules map[string]string
+	// JTITracker, when set, limits the distinct tokens a subject may present within a window
+	JTITracker *JTITracker
+	// TokenCache, when set, caches tokens whose signature has been verified until they expire
+	TokenCache *TokenCache
+	// NonceHeader names the request header carrying the nonce the client expects in the token's nonce claim
+	NonceHeader string
+	// CertBoundTokens requires tokens bound to the TLS client certificate they are presented with (RFC 8705)
+	CertBoundTokens bool
+	// TokenVersionClaim names the claim carrying the token format version (e.g. "ver")
+	TokenVersionClaim string
+	// TokenVersion, when set, requires the version claim to equal this value
+	TokenVersion string
+	// MinTokenVersion, when positive, requires the version claim to be numeric and at least this value
+	MinTokenVersion float64
+	// IntrospectionURL is the RFC 7662 token introspection endpoint used for opaque (non-JWT) tokens
+	IntrospectionURL string
+	// IntrospectionClientID and IntrospectionClientSecret authenticate this server to the introspection endpoint
+	IntrospectionClientID     string
+	IntrospectionClientSecret string
+	// IntrospectionTimeout bounds each introspection attempt (10 seconds when zero)
+	IntrospectionTimeout time.Duration
+	// IntrospectionRetries is how many times a failed introspection call is retried
+	IntrospectionRetries int
+	// IntrospectionBreakerThreshold opens the circuit after this many consecutive failed introspections; disabled when 0
+	IntrospectionBreakerThreshold int
+	// IntrospectionBreakerCooldown is how long the circuit stays open (30 seconds when zero)
+	IntrospectionBreakerCooldown time.Duration
+	// IntrospectionBreakerFailClosed answers 401 instead of 503 while the circuit is open
+	IntrospectionBreakerFailClosed bool
+	introspectionBreaker           introspectionBreaker
+	// AllowedAlgorithms lists the accepted JWT signing algorithms (RS256 when empty)
+	AllowedAlgorithms []string
+	// JwksRefreshInterval is how often the JWKS is refreshed in the background (1 hour when zero)
+	JwksRefreshInterval time.Duration
+	// TrustedIssuers lists the accepted token issuers (AuthzServerURL when empty)
+	TrustedIssuers []string
+	// IssuerJwksURLs maps federated issuers to their own JWKS URLs; tokens from other issuers use JwksURL
+	IssuerJwksURLs map[string]string
+	// InlineJWKS is a JWK Set JSON used instead of fetching JwksURL, for self-contained deployments without network access
+	InlineJWKS string
+	// JwksCacheFile persists the fetched JWKS so the server can start while the authorization server is unreachable
+	JwksCacheFile string
+	// JwksCacheMaxAge is the maximum age of a cached JWKS that is still used at startup
+	JwksCacheMaxAge time.Duration
+	// JwksMaxStaleness rejects tokens once their JWKS has not been refreshed successfully for this long; disabled when 0
+	JwksMaxStaleness time.Duration
+	jwksFetches      jwksFetchRecorder
+	jwksMu           sync.Mutex
+	jwks             keyfunc.Keyfunc
+	issuerJWKS       map[string]keyfunc.Keyfunc
+	jwksCancel       context.CancelFunc
 }
 
-// InitJWKS initializes the JWKS client
+// InitJWKS initializes the JWKS client, or defers it to the first request when LazyJWKS is set
 func (c *OAuthConfig) InitJWKS() error {
-	jwks, err := keyfunc.NewDefault([]string{c.JwksURL})
+	for _, alg := range c.AllowedAlgorithms {
+		// "none" would disable signature checks entirely, so it is never allowed
+		if alg == "none" || jwt.GetSigningMethod(alg) == nil {
+			return fmt.Errorf("unsupported signing algorithm %q", alg)
+		}
+	}
+
+	if !c.hasJWKS() {
+		logger.Info("JWKS disabled; all tokens are validated via introspection")
+		return nil
+	}
+
+	if c.deferredJWKS() {
+		logger.Info("JWKS initialization deferred until first request", "jwks_url", c.JwksURL)
+		return nil
+	}
+	_, err := c.loadJWKS()
+	return err
+}
+
+// deferredJWKS reports whether the JWKS is fetched on the first token rather than at startup.
+// An inline JWKS needs no network access, so it is always validated at startup.
+func (c *OAuthConfig) deferredJWKS() bool {
+	return c.LazyJWKS && c.InlineJWKS == ""
+}
+
+// loadJWKS returns the JWKS client, creating it on first use.
+// The lock ensures concurrent first requests do not fetch the JWKS twice.
+func (c *OAuthConfig) loadJWKS() (keyfunc.Keyfunc, error) {
+	c.jwksMu.Lock()
+	defer c.jwksMu.Unlock()
+	if c.jwks != nil {
+		return c.jwks, nil
+	}
+
+	// When a token carries a kid that is not cached (e.g. during key rotation), the JWKS is refreshed
+	// at most once per interval and the key lookup retried before the token is rejected.
+	// Keys are also refreshed in the background every JwksRefreshInterval; a failed refresh keeps the last-good keys.
+	ctx, cancel := context.WithCancel(context.Background())
+	var jwks keyfunc.Keyfunc
+	var err error
+	if c.InlineJWKS != "" {
+		jwks, err = newInlineJWKS(c.InlineJWKS)
+	} else if c.JwksCacheFile != "" {
+		jwks, err = c.newCachedJWKS(ctx)
+	} else {
+		jwks, err = c.newJWKS(ctx, c.JwksURL)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create JWKS client: %w", err)
+		cancel()
+		return nil, fmt.Errorf("failed to create JWKS client: %w", err)
 	}
+
+	// Each federated issuer has its own signing keys
+	issuerJWKS := make(map[string]keyfunc.Keyfunc, len(c.IssuerJwksURLs))
+	for iss, u := range c.IssuerJwksURLs {
+		issuerJWKS[iss], err = c.newJWKS(ctx, u)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to create JWKS client for issuer %s: %w", iss, err)
+		}
+		logger.Info("Initialized JWKS for issuer", "issuer", iss, "jwks_url", u)
+	}
+
 	c.jwks = jwks
-	log.Printf("Initialized JWKS from: %s", c.JwksURL)
-	return nil
+	c.issuerJWKS = issuerJWKS
+	c.jwksCancel = cancel
+	if c.InlineJWKS != "" {
+		logger.Info("Initialized inline JWKS")
+	} else {
+		logger.Info("Initialized JWKS", "jwks_url", c.JwksURL)
+	}
+	return jwks, nil
+}
+
+// hasJWKS reports whether tokens can be verified locally, from JwksURL or InlineJWKS
+func (c *OAuthConfig) hasJWKS() bool {
+	return c.JwksURL != "" || c.InlineJWKS != ""
+}
+
+// newInlineJWKS creates a JWKS client from a JWK Set JSON, rejecting sets without usable keys
+func newInlineJWKS(raw string) (keyfunc.Keyfunc, error) {
+	jwks, err := keyfunc.NewJWKSetJSON(json.RawMessage(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid inline JWKS: %w", err)
+	}
+	if keys, err := jwks.Storage().KeyReadAll(context.Background()); err != nil || len(keys) == 0 {
+		return nil, errors.New("invalid inline JWKS: no keys")
+	}
+	return jwks, nil
+}
+
+// newJWKS creates a JWKS client for the JWKS at u, refreshed in the background until ctx is done
+func (c *OAuthConfig) newJWKS(ctx context.Context, u string) (keyfunc.Keyfunc, error) {
+	return keyfunc.NewDefaultOverrideCtx(ctx, []string{u}, keyfunc.Override{
+		Client:                  c.jwksClient(),
+		RefreshInterval:         c.JwksRefreshInterval,
+		RefreshUnknownKID:       rate.NewLimiter(rate.Every(c.unknownKIDRefreshInterval()), 1),
+		RefreshErrorHandlerFunc: jwksRefreshErrorHandler,
+	})
+}
+
+// jwksForIssuer returns the JWKS client of the token's issuer when it has its own JWKS, or jwks otherwise.
+// The issuer is read before signature verification; a forged iss only selects keys that will not verify the token.
+func (c *OAuthConfig) jwksForIssuer(jwks keyfunc.Keyfunc, iss string) keyfunc.Keyfunc {
+	c.jwksMu.Lock()
+	defer c.jwksMu.Unlock()
+	if issuerJWKS, ok := c.issuerJWKS[iss]; ok {
+		return issuerJWKS
+	}
+	return jwks
+}
+
+// Close stops the background JWKS refresh
+func (c *OAuthConfig) Close() {
+	c.jwksMu.Lock()
+	defer c.jwksMu.Unlock()
+	if c.jwksCancel != nil {
+		c.jwksCancel()
+		c.jwksCancel = nil
+	}
+}
+
+// resetJWKS discards the JWKS clients, so the next use fetches the key sets again
+func (c *OAuthConfig) resetJWKS() {
+	c.jwksMu.Lock()
+	defer c.jwksMu.Unlock()
+	if c.jwksCancel != nil {
+		c.jwksCancel()
+		c.jwksCancel = nil
+	}
+	c.jwks, c.issuerJWKS = nil, nil
+}
+
+// issuerJWKSSnapshot returns the JWKS clients of the federated issuers
+func (c *OAuthConfig) issuerJWKSSnapshot() map[string]keyfunc.Keyfunc {
+	c.jwksMu.Lock()
+	defer c.jwksMu.Unlock()
+	return maps.Clone(c.issuerJWKS)
+}
+
+// jwksRefreshErrorHandler logs failed JWKS refreshes for the JWKS at u
+func jwksRefreshErrorHandler(u string) func(ctx context.Context, err error) {
+	return func(ctx context.Context, err error) {
+		logger.Warn("Failed to refresh JWKS, keeping previous keys", "jwks_url", u, "error", err)
+	}
+}
+
+// unknownKIDRefreshInterval returns the minimum interval between unknown-kid refreshes (default 5 minutes)
+func (c *OAuthConfig) unknownKIDRefreshInterval() time.Duration {
+	if c.JwksUnknownKIDRefreshInterval <= 0 {
+		return 5 * time.Minute
+	}
+	return c.JwksUnknownKIDRefreshInterval
+}
+
+// lookupKey resolves the verification key for a token.
+// Concurrent lookups of the same kid share one call, so a burst of unknown-kid tokens triggers a single refresh.
+func (c *OAuthConfig) lookupKey(jwks keyfunc.Keyfunc) jwt.Keyfunc {
+	return func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		alg, _ := token.Header["alg"].(string)
+		// Checked before the lookup so disallowed kids never trigger a JWKS refresh
+		if len(c.AllowedKIDs) > 0 && !slices.Contains(c.AllowedKIDs, kid) {
+			return nil, fmt.Errorf("%w: %q", errKIDNotAllowed, kid)
+		}
+		iss, _ := token.Claims.(jwt.MapClaims)["iss"].(string)
+		key, err, _ := c.keyLookups.Do(iss+"\x00"+kid+"\x00"+alg, func() (any, error) {
+			return jwks.Keyfunc(token)
+		})
+		return key, err
+	}
+}
+
+// currentJWKS returns the JWKS client if it has been initialized, without initializing it
+func (c *OAuthConfig) currentJWKS() keyfunc.Keyfunc {
+	c.jwksMu.Lock()
+	defer c.jwksMu.Unlock()
+	return c.jwks
+}
+
+// allowedAlgorithms returns the accepted signing algorithms, defaulting to RS256
+func (c *OAuthConfig) allowedAlgorithms() []string {
+	if len(c.AllowedAlgorithms) == 0 {
+		return []string{"RS256"}
+	}
+	return c.AllowedAlgorithms
+}
+
+// jwksUnavailable reports whether a token failed to verify because no signing keys could be fetched,
+// as opposed to a problem with the token itself (bad signature, unknown or disallowed key ID)
+func (c *OAuthConfig) jwksUnavailable(ctx context.Context, jwks keyfunc.Keyfunc, err error) bool {
+	if jwks == nil {
+		return true
+	}
+	if !errors.Is(err, jwt.ErrTokenUnverifiable) || errors.Is(err, errKIDNotAllowed) {
+		return false
+	}
+	keys, kerr := jwks.Storage().KeyReadAll(ctx)
+	return kerr == nil && len(keys) == 0
+}
+
+// parseWithoutSignature parses a token and validates its registered claims without checking the signature
+func (c *OAuthConfig) parseWithoutSignature(tokenString string) (*jwt.Token, error) {
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return nil, err
+	}
+	if err := jwt.NewValidator(jwt.WithLeeway(c.ClockSkew)).Validate(token.Claims); err != nil {
+		return token, err
+	}
+	token.Valid = true
+	return token, nil
+}
+
+// jwksHasKey reports whether the cached JWKS contains the key ID, without triggering a refresh
+func (c *OAuthConfig) jwksHasKey(ctx context.Context, kid string) bool {
+	jwks := c.currentJWKS()
+	if jwks == nil {
+		return false
+	}
+	keys, err := jwks.Storage().KeyReadAll(ctx)
+	if err != nil {
+		return false
+	}
+	for _, k := range keys {
+		if k.Marshal().KID == kid {
+			return true
+		}
+	}
+	return false
 }
 
 // OAuthMiddleware is a middleware that performs OAuth 2.1 authorization
 func (c *OAuthConfig) OAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), authStartKey{}, time.Now()))
+
 		// Check Authorization header
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			c.sendUnauthorized(w, r)
+			c.sendUnauthorized(w, r, authErrorMissingToken, "missing Authorization header")
 			return
 		}
 
 		// Extract Bearer token
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		if tokenString == authHeader {
-			c.sendUnauthorized(w, r)
+			c.sendUnauthorized(w, r, authErrorMissingToken, "Authorization header is not a Bearer token")
+			return
+		}
+
+		// Development token bypasses JWT validation entirely (only when explicitly configured)
+		if c.DevToken != "" && subtle.ConstantTimeCompare([]byte(tokenString), []byte(c.DevToken)) == 1 {
+			logger.Warn("Development token used; JWT validation bypassed", "remote_addr", r.RemoteAddr)
+			c.serveAuthorized(w, r, next, c.devClaims())
 			return
 		}
 
+		// Detect alg=none explicitly: it is a classic attack and deserves a distinct warning
+		if isAlgNone(tokenString) {
+			stats.algNoneRejected.Add(1)
+			algNoneRejectedTotal.Inc()
+			logger.Warn("SECURITY: alg=none token rejected", "remote_addr", r.RemoteAddr)
+			c.sendUnauthorized(w, r, authErrorInvalidToken, "unsigned (alg=none) tokens are not accepted")
+			return
+		}
+
+		// Opaque (non-JWT) tokens are validated via the introspection endpoint, when configured
+		if c.IntrospectionURL != "" && (!c.hasJWKS() || !isJWT(tokenString)) {
+			claims, err := c.introspect(r.Context(), tokenString)
+			var circuitOpen *errIntrospectionCircuitOpen
+			if errors.As(err, &circuitOpen) && !c.IntrospectionBreakerFailClosed {
+				logger.Warn("Rejected request: introspection circuit open", "retry_after", circuitOpen.retryAfter)
+				authTotal.WithLabelValues("unavailable").Inc()
+				observeAuthDuration(r, "unavailable")
+				recordDecision(r, "auth", "deny(introspection unavailable)")
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(circuitOpen.retryAfter)))
+				writeError(w, http.StatusServiceUnavailable, "token introspection unavailable")
+				return
+			}
+			if err != nil {
+				logger.Warn("Token introspection failed", "error", err)
+				c.sendUnauthorized(w, r, authErrorInvalidToken, "token introspection failed")
+				return
+			}
+			if active, _ := claims["active"].(bool); !active {
+				logger.Warn("Introspection reports the token is not active")
+				c.sendUnauthorized(w, r, authErrorInvalidToken, "token is not active")
+				return
+			}
+			c.authorizeClaims(w, r, next, claims)
+			return
+		}
+
+		// A token verified by an earlier request skips signature verification; its claims are still validated
+		if c.TokenCache != nil {
+			if claims, ok := c.TokenCache.Get(tokenString); ok {
+				logger.Debug("Token signature verification skipped (cached)")
+				c.authorizeClaims(w, r, next, claims)
+				return
+			}
+		}
+
+		jwks, err := c.loadJWKS()
+		if err != nil {
+			logger.Error("Failed to initialize JWKS", "error", err)
+			if !c.JwksFailOpen {
+				c.sendUnauthorized(w, r, authErrorInvalidToken, "signing keys unavailable")
+				return
+			}
+		}
+
 		// Validate JWT token using JWKS with algorithm validation
-		token, err := jwt.Parse(tokenString, c.jwks.Keyfunc, jwt.WithValidMethods([]string{"RS256"}))
+		var token *jwt.Token
+		var verified bool
+		if jwks != nil {
+			iss, _ := unverifiedClaims(r)["iss"].(string)
+			if age, stale := c.jwksStale(iss); stale {
+				logger.Warn("JWKS has not been refreshed within the staleness limit; rejecting token", "age", age.Round(time.Second), "max", c.JwksMaxStaleness)
+				c.sendUnauthorized(w, r, authErrorInvalidToken, "signing keys are stale")
+				return
+			}
+			jwks = c.jwksForIssuer(jwks, iss)
+			token, err = jwt.Parse(tokenString, c.lookupKey(jwks), jwt.WithValidMethods(c.allowedAlgorithms()), jwt.WithLeeway(c.ClockSkew))
+			verified = err == nil
+		}
+		if err != nil && c.JwksFailOpen && c.jwksUnavailable(r.Context(), jwks, err) {
+			logger.Warn("JWKS unavailable; accepting token WITHOUT signature verification because -jwks-failure-mode=open", "error", err)
+			token, err = c.parseWithoutSignature(tokenString)
+		}
+		if token != nil {
+			// Debug: Header details are the fastest way to diagnose key rotation/config issues
+			kid, _ := token.Header["kid"].(string)
+			logger.Debug("Token header", "kid", kid, "alg", token.Header["alg"], "kid_in_jwks", c.jwksHasKey(r.Context(), kid))
+		}
 		if err != nil {
-			log.Printf("Failed to parse token: %v", err)
-			c.sendUnauthorized(w, r)
+			switch {
+			case errors.Is(err, errKIDNotAllowed):
+				logger.Warn("Token signed with a key ID that is not in the allowed key IDs", "kid", token.Header["kid"])
+			case errors.Is(err, jwkset.ErrKeyNotFound):
+				logger.Warn("Token signed with unknown key ID (not found after JWKS refresh)", "kid", token.Header["kid"])
+			case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+				logger.Warn("Token signature is invalid", "kid", token.Header["kid"])
+			}
+			logger.Warn("Failed to parse token", "error", err)
+			c.sendUnauthorized(w, r, authErrorInvalidToken, fmt.Sprintf("failed to parse token: %v", err))
 			return
 		}
 
 		if !token.Valid {
-			log.Printf("Invalid token")
-			c.sendUnauthorized(w, r)
+			logger.Warn("Invalid token")
+			c.sendUnauthorized(w, r, authErrorInvalidToken, "invalid token")
 			return
 		}
 
 		// Get claims for validation
 		claims, ok := token.Claims.(jwt.MapClaims)
 		if !ok {
-			log.Printf("Invalid claims type")
-			c.sendUnauthorized(w, r)
+			logger.Warn("Invalid claims type")
+			c.sendUnauthorized(w, r, authErrorInvalidToken, "invalid claims type")
 			return
 		}
 
-		// Debug: Dump JWT access token before validation
-		log.Printf("=== JWT Access Token Debug ===")
-		log.Printf("Raw Token: %s", tokenString)
-		claimsJSON, _ := json.MarshalIndent(claims, "", "  ")
-		log.Printf("Claims: %s", string(claimsJSON))
-		log.Printf("===============================")
+		// Tokens accepted without signature verification (fail-open) must not be cached as verified
+		if c.TokenCache != nil && verified {
+			c.TokenCache.Add(tokenString, claims)
+		}
 
-		// Validate audience (MUST): Verify this resource server is in the audience
-		if !c.validateAudience(claims) {
-			log.Printf("Invalid audience")
-			c.sendUnauthorized(w, r)
-			return
+		// Track clients still presenting tokens signed by a key that is being retired
+		if kid, _ := token.Header["kid"].(string); slices.Contains(c.DeprecatedKIDs, kid) {
+			sub, _ := claims["sub"].(string)
+			logger.Warn("Token signed with deprecated key ID", "kid", kid, "sub", c.logSubject(sub), "client_id", clientID(claims))
+			deprecatedKIDTokensTotal.WithLabelValues(kid).Inc()
 		}
 
-		// Validate issuer (MUST): Verify token is issued by expected authorization server
-		if !c.validateIssuer(claims) {
-			log.Printf("Invalid issuer")
-			c.sendUnauthorized(w, r)
-			return
+		// Debug: Dump the decoded JWT header and payload before validation; the raw token and signature are never logged
+		if c.DebugAuth {
+			logger.Debug("JWT access token", "header", token.Header, "claims", c.loggableClaims(claims))
 		}
 
-		// Validate expiration (MUST): Ensure token is not expired
-		// Note: jwt.Parse already validates exp by default, but we explicitly check here for clarity
-		if !c.validateExpiration(claims) {
-			log.Printf("Token expired")
-			c.sendUnauthorized(w, r)
-			return
+		c.authorizeClaims(w, r, next, claims)
+	})
+}
+
+// authorizeClaims validates the claims of a verified token and serves the request when they are acceptable
+func (c *OAuthConfig) authorizeClaims(w http.ResponseWriter, r *http.Request, next http.Handler, claims jwt.MapClaims) {
+	// Validate audience (MUST): Verify this resource server is in the audience
+	if !c.validateAudience(claims, r) {
+		logger.Warn("Invalid audience", "aud", claims["aud"])
+		if slices.Contains(tokenAudiences(claims), c.AuthzServerURL) {
+			logger.Warn("Token audience is the authorization server, not this resource; configure the client's resource/audience", "authz_server_url", c.AuthzServerURL, "resource_url", c.ResourceURL)
 		}
+		c.sendUnauthorized(w, r, authErrorInvalidToken, "invalid audience")
+		return
+	}
+
+	// Unusually many audiences may indicate a misissued token
+	if n := len(tokenAudiences(claims)); c.MaxAudiences > 0 && n > c.MaxAudiences {
+		logger.Warn("Token has too many audiences", "count", n, "max", c.MaxAudiences)
+		c.sendUnauthorized(w, r, authErrorInvalidToken, "too many audiences")
+		return
+	}
+
+	// Validate issuer (MUST): Verify token is issued by expected authorization server
+	if !c.validateIssuer(claims) {
+		logger.Warn("Invalid issuer", "iss", claims["iss"])
+		c.sendUnauthorized(w, r, authErrorInvalidToken, "invalid issuer")
+		return
+	}
+
+	// Validate expiration (MUST): Ensure token is not expired
+	// Note: jwt.Parse already validates exp by default, but we explicitly check here for clarity
+	if !c.validateExpiration(claims) {
+		logger.Warn("Token expired", "exp", claims["exp"])
+		c.sendUnauthorized(w, r, authErrorInvalidToken, "token expired")
+		return
+	}
 
-		// Validate scope: Verify token has required scopes (optional, depends on your requirements)
-		if !c.validateScope(claims) {
-			log.Printf("Insufficient scope")
-			c.sendUnauthorized(w, r)
+	// Validate not-before (optional claim): Reject tokens used before their activation time
+	if !c.validateNotBefore(claims) {
+		logger.Warn("Token not yet valid", "nbf", claims["nbf"])
+		c.sendUnauthorized(w, r, authErrorInvalidToken, "token not yet valid")
+		return
+	}
+
+	// Validate issued-at (optional claim): A future iat points to clock problems or forgery, unlike a future nbf
+	if !c.validateIssuedAt(claims) {
+		iat, _ := claims["iat"].(float64)
+		logger.Warn("SECURITY: token iat is in the future", "iat", int64(iat), "now", time.Now().Unix(), "skew", c.ClockSkew)
+		c.sendUnauthorized(w, r, authErrorInvalidToken, "token issued in the future")
+		return
+	}
+
+	// Validate subject format (optional): Verify sub matches the configured pattern
+	if !c.validateSubject(claims) {
+		logger.Warn("Invalid subject")
+		c.sendUnauthorized(w, r, authErrorInvalidToken, "subject does not match the required pattern")
+		return
+	}
+
+	// Validate nonce (optional): When the client sends the nonce it expects, the token must echo it
+	if !c.validateNonce(claims, r) {
+		logger.Warn("Token nonce does not match the nonce sent by the client", "header", c.NonceHeader)
+		c.sendUnauthorized(w, r, authErrorInvalidToken, "nonce mismatch")
+		return
+	}
+
+	// Validate certificate binding (optional): A token stolen without the client's private key cannot be replayed
+	if !c.validateCertBinding(claims, r) {
+		logger.Warn("SECURITY: token is not bound to the client certificate of the connection", "remote_addr", r.RemoteAddr)
+		c.sendUnauthorized(w, r, authErrorInvalidToken, "token is not bound to the client certificate")
+		return
+	}
+
+	// Validate token format version (optional): Reject older token formats during a migration
+	if !c.validateTokenVersion(claims) {
+		logger.Warn("Unsupported token version", "claim", c.TokenVersionClaim, "version", claims[c.TokenVersionClaim])
+		c.sendUnauthorized(w, r, authErrorInvalidToken, "unsupported token version")
+		return
+	}
+
+	// Validate scope: Verify token has required scopes (optional, depends on your requirements)
+	if !c.validateScope(claims) {
+		logger.Warn("Insufficient scope", "scope", claims["scope"], "required", c.RequiredScopes)
+		c.sendUnauthorized(w, r, authErrorInsufficientScope, "insufficient scope")
+		return
+	}
+
+	// Validate scope/audience consistency (optional): A resource-specific scope requires that resource in aud
+	if scope, aud, ok := c.validateScopeAudience(claims); !ok {
+		logger.Warn("Inconsistent token: scope implies an audience missing from aud", "scope", scope, "implied_aud", aud, "aud", tokenAudiences(claims))
+		c.sendUnauthorized(w, r, authErrorInvalidToken, "scope implies an audience missing from the token")
+		return
+	}
+
+	// Detect credential sharing (optional): Reject subjects presenting too many distinct tokens
+	if c.JTITracker != nil {
+		sub, _ := claims["sub"].(string)
+		if jti, ok := claims["jti"].(string); ok && !c.JTITracker.Observe(sub, jti) {
+			logger.Warn("SECURITY: subject presented too many distinct tokens", "sub", c.logSubject(sub), "max", c.JTITracker.max, "window", c.JTITracker.window)
+			c.sendUnauthorized(w, r, authErrorInvalidToken, "too many distinct tokens for subject")
 			return
 		}
+	}
+
+	// Authorization successful - proceed to next handler
+	c.serveAuthorized(w, r, next, claims)
+}
+
+// serveAuthorized passes an authorized request to next with the validated claims attached
+func (c *OAuthConfig) serveAuthorized(w http.ResponseWriter, r *http.Request, next http.Handler, claims jwt.MapClaims) {
+	stats.authSuccess.Add(1)
+	authTotal.WithLabelValues("success").Inc()
+	observeAuthDuration(r, "success")
+	recordDecision(r, "auth", "pass")
+	sub, _ := claims["sub"].(string)
+	logger.Info("Authorized subject", "sub", c.logSubject(sub))
+	if c.SecurityLog != nil {
+		c.SecurityLog.Log(r, "allow", "", c.logSubject(sub), clientID(claims))
+	}
+
+	// Bind the request lifetime to the token lifetime so long-lived streams stop once the token expires.
+	// The clock skew is included so a token accepted within the leeway is not cut off immediately.
+	exp, _ := claims["exp"].(float64)
+	deadline := time.Unix(int64(exp), 0).Add(c.ClockSkew + c.SessionExpiryGrace)
+	scopes := extractScopes(claims)
+	ctx := context.WithValue(r.Context(), claimsContextKey{}, claims)
+	ctx = context.WithValue(ctx, scopesContextKey{}, scopes)
+	ctx, cancel := context.WithDeadlineCause(ctx, deadline, errTokenExpiredMidSession)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		if errors.Is(context.Cause(ctx), errTokenExpiredMidSession) {
+			logger.Info("Closing request: access token expired during session", "method", r.Method, "path", r.URL.Path)
+		}
+	})
+	defer stop()
 
-		// Authorization successful - proceed to next handler
+	// Expose the validated token to MCP tool handlers through req.Extra.TokenInfo.
+	// The SDK middleware reads the Authorization header, so claim headers are applied after it.
+	tokenInfo := &auth.TokenInfo{Scopes: scopes, Expiration: deadline, Extra: claims}
+	verifier := func(context.Context, string, *http.Request) (*auth.TokenInfo, error) {
+		return tokenInfo, nil
+	}
+	downstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.applyClaimHeaders(r, claims)
 		next.ServeHTTP(w, r)
 	})
+
+	auth.RequireBearerToken(verifier, nil)(downstream).ServeHTTP(w, r.WithContext(ctx))
 }
 
-// validateAudience validates that the token's audience matches this resource server
-func (c *OAuthConfig) validateAudience(claims jwt.MapClaims) bool {
-	aud, ok := claims["aud"]
-	if !ok {
+// devClaims returns the fake claims injected for the development token
+func (c *OAuthConfig) devClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   c.AuthzServerURL,
+		"aud":   c.ResourceURL,
+		"sub":   c.DevSubject,
+		"scope": strings.Join(c.DevScopes, " "),
+		"exp":   float64(time.Now().Add(time.Hour).Unix()),
+	}
+}
+
+// isAlgNone reports whether the token header declares the "none" algorithm
+func isAlgNone(tokenString string) bool {
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
 		return false
 	}
+	alg, _ := token.Header["alg"].(string)
+	return strings.EqualFold(alg, "none")
+}
+
+// validateAudience validates that the token's audience matches this resource server
+func (c *OAuthConfig) validateAudience(claims jwt.MapClaims, r *http.Request) bool {
+	expected := c.AcceptedAudiences
+	if len(expected) == 0 {
+		expected = []string{c.ResourceURL}
+	}
+	if c.AudienceFromRequestURL {
+		// Strict RFC 8707 binding: the token must be issued for the exact URL being accessed.
+		// Host and X-Forwarded-Host are sent by the client, so the URL must also lie within a configured audience;
+		// otherwise a token for any other host would be accepted by sending that Host.
+		reqURL := c.requestURL(r)
+		within := func(aud string) bool { return urlWithin(reqURL, aud) }
+		if !slices.ContainsFunc(expected, within) && !slices.ContainsFunc(c.fileAudiences(), within) {
+			logger.Warn("Request URL is not within any configured audience", "url", reqURL)
+			return false
+		}
+		expected = []string{reqURL}
+	}
+
+	// Accept both the configured form and its canonical form, as clients may normalize the URL
+	var acceptedAudiences []string
+	for _, aud := range expected {
+		acceptedAudiences = append(acceptedAudiences, aud, canonicalURL(aud))
+	}
+	acceptedAudiences = append(acceptedAudiences, c.fileAudiences()...)
+	accepted := func(aud string) bool {
+		return slices.Contains(acceptedAudiences, aud)
+	}
+
+	audiences := tokenAudiences(claims)
+	if c.ExclusiveAudience {
+		// Every audience must be this resource, limiting the blast radius of a leaked token
+		return len(audiences) > 0 && !slices.ContainsFunc(audiences, func(aud string) bool { return !accepted(aud) })
+	}
+	return slices.ContainsFunc(audiences, accepted)
+}
+
+// urlWithin reports whether u is base or below it, comparing canonical forms
+func urlWithin(u, base string) bool {
+	u, base = canonicalURL(u), canonicalURL(base)
+	return u == base || strings.HasPrefix(u, base+"/")
+}
+
+// canonicalURL returns the canonical form of a URL: lowercase scheme and host, no default port and no trailing slash
+func canonicalURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return raw
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if port := u.Port(); (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		u.Host = u.Hostname()
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u.String()
+}
 
-	// aud can be a string or array of strings
-	switch v := aud.(type) {
+// tokenAudiences returns the aud claim as a list; aud can be a string or array of strings
+func tokenAudiences(claims jwt.MapClaims) []string {
+	switch v := claims["aud"].(type) {
 	case string:
-		return v == c.ResourceURL
+		return []string{v}
 	case []interface{}:
+		var audiences []string
 		for _, a := range v {
-			if audStr, ok := a.(string); ok && audStr == c.ResourceURL {
-				return true
+			if audStr, ok := a.(string); ok {
+				audiences = append(audiences, audStr)
 			}
 		}
-		return false
+		return audiences
 	default:
-		return false
+		return nil
 	}
 }
 
+// requestURL reconstructs the absolute URL (scheme, host and path) of the request
+func (c *OAuthConfig) requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if c.TrustProxyHeaders {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+			scheme = strings.TrimSpace(strings.Split(proto, ",")[0])
+		}
+		if fwdHost := r.Header.Get("X-Forwarded-Host"); fwdHost != "" {
+			host = strings.TrimSpace(strings.Split(fwdHost, ",")[0])
+		}
+	}
+	return scheme + "://" + host + r.URL.Path
+}
+
 // validateIssuer validates that the token's issuer matches the expected authorization server
 func (c *OAuthConfig) validateIssuer(claims jwt.MapClaims) bool {
 	iss, ok := claims["iss"].(string)
 	if !ok {
 		return false
 	}
-	return iss == c.AuthzServerURL
+	return slices.Contains(c.trustedIssuers(), iss)
+}
+
+// trustedIssuers returns the accepted issuers: TrustedIssuers (AuthzServerURL when empty) and the IssuerJwksURLs issuers
+func (c *OAuthConfig) trustedIssuers() []string {
+	issuers := c.TrustedIssuers
+	if len(issuers) == 0 {
+		issuers = []string{c.AuthzServerURL}
+	}
+	for iss := range c.IssuerJwksURLs {
+		if !slices.Contains(issuers, iss) {
+			issuers = append(slices.Clip(issuers), iss)
+		}
+	}
+	return issuers
 }
 
 // validateExpiration validates that the token has not expired
@@ -152,79 +854,451 @@ func (c *OAuthConfig) validateExpiration(claims jwt.MapClaims) bool {
 	if !ok {
 		return false
 	}
-	// Allow 60 seconds of clock skew
-	return time.Now().Unix() < int64(exp)+60
+	// Allow for clock skew between this server and the authorization server
+	return time.Now().Unix() < int64(exp)+int64(c.ClockSkew.Seconds())
 }
 
-// validateScope validates that the token has required scopes
-func (c *OAuthConfig) validateScope(claims jwt.MapClaims) bool {
-	scope, ok := claims["scope"].(string)
+// validateNotBefore validates that the token is already valid; nbf is optional
+func (c *OAuthConfig) validateNotBefore(claims jwt.MapClaims) bool {
+	nbf, ok := claims["nbf"].(float64)
+	if !ok {
+		return true
+	}
+	return time.Now().Unix() >= int64(nbf)-int64(c.ClockSkew.Seconds())
+}
+
+// validateIssuedAt validates that the token was not issued in the future; iat is optional
+func (c *OAuthConfig) validateIssuedAt(claims jwt.MapClaims) bool {
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		return true
+	}
+	return int64(iat) <= time.Now().Unix()+int64(c.ClockSkew.Seconds())
+}
+
+// validateSubject validates that the token's subject matches the configured pattern
+func (c *OAuthConfig) validateSubject(claims jwt.MapClaims) bool {
+	if c.SubPattern == nil {
+		return true
+	}
+	sub, ok := claims["sub"].(string)
 	if !ok {
 		return false
 	}
-	// Scope is a space-separated string (OAuth 2.0 standard)
-	// Check if "mcp:tools" is present
-	for _, s := range strings.Split(scope, " ") {
-		if s == "mcp:tools" {
-			return true
+	return c.SubPattern.MatchString(sub)
+}
+
+// validateScopeAudience checks every token scope against ScopeAudienceRules (scope prefix -> required audience).
+// It returns the first scope whose implied audience is missing from aud.
+func (c *OAuthConfig) validateScopeAudience(claims jwt.MapClaims) (scope, aud string, ok bool) {
+	if len(c.ScopeAudienceRules) == 0 {
+		return "", "", true
+	}
+	audiences := tokenAudiences(claims)
+	for _, scope := range extractScopes(claims) {
+		for prefix, aud := range c.ScopeAudienceRules {
+			if strings.HasPrefix(scope, prefix) && !slices.Contains(audiences, aud) {
+				return scope, aud, false
+			}
 		}
 	}
-	return false
+	return "", "", true
 }
 
-// sendUnauthorized sends a 401 response with WWW-Authenticate header
-func (c *OAuthConfig) sendUnauthorized(w http.ResponseWriter, r *http.Request) {
-	metadataURL := c.ResourceURL + "/.well-known/oauth-protected-resource"
-	w.Header().Set("WWW-Authenticate",
-		fmt.Sprintf(`Bearer resource_metadata="%s"`, metadataURL))
-	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+// validateNonce validates that the token's nonce claim matches the nonce the client sent in NonceHeader, if any
+func (c *OAuthConfig) validateNonce(claims jwt.MapClaims, r *http.Request) bool {
+	if c.NonceHeader == "" {
+		return true
+	}
+	expected := r.Header.Get(c.NonceHeader)
+	if expected == "" {
+		return true
+	}
+	nonce, _ := claims["nonce"].(string)
+	return subtle.ConstantTimeCompare([]byte(nonce), []byte(expected)) == 1
 }
 
-// HandleProtectedResourceMetadata handles the protected resource metadata endpoint
-func (c *OAuthConfig) HandleProtectedResourceMetadata(w http.ResponseWriter, r *http.Request) {
-	// Set CORS headers
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+// validateCertBinding validates that the token's cnf x5t#S256 thumbprint is the SHA-256 of the TLS client
+// certificate of the connection, when CertBoundTokens is set
+func (c *OAuthConfig) validateCertBinding(claims jwt.MapClaims, r *http.Request) bool {
+	if !c.CertBoundTokens {
+		return true
+	}
+	cnf, _ := claims["cnf"].(map[string]any)
+	thumbprint, _ := cnf["x5t#S256"].(string)
+	if thumbprint == "" || r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return false
+	}
+	sum := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+	return subtle.ConstantTimeCompare([]byte(thumbprint), []byte(base64.RawURLEncoding.EncodeToString(sum[:]))) == 1
+}
 
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
+// validateTokenVersion validates the token format version claim against the configured value or minimum
+func (c *OAuthConfig) validateTokenVersion(claims jwt.MapClaims) bool {
+	if c.TokenVersion == "" && c.MinTokenVersion <= 0 {
+		return true
 	}
+	value, ok := claims[c.TokenVersionClaim]
+	if !ok {
+		return false
+	}
+	version := fmt.Sprint(value)
+	if c.TokenVersion != "" && version != c.TokenVersion {
+		return false
+	}
+	if c.MinTokenVersion > 0 {
+		n, err := strconv.ParseFloat(version, 64)
+		if err != nil || n < c.MinTokenVersion {
+			return false
+		}
+	}
+	return true
+}
+
+// extractScopes returns the token scopes as a list.
+// scope is normally a space-separated string (OAuth 2.0 standard), but some IdPs emit a JSON array.
+func extractScopes(claims jwt.MapClaims) []string {
+	switch v := claims["scope"].(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var scopes []string
+		for _, s := range v {
+			if str, ok := s.(string); ok {
+				scopes = append(scopes, str)
+			}
+		}
+		return scopes
+	default:
+		return nil
+	}
+}
+
+// validateScope validates that the token has all of the required scopes
+func (c *OAuthConfig) validateScope(claims jwt.MapClaims) bool {
+	// Scope is a space-separated string (OAuth 2.0 standard), or a JSON array with some IdPs
+	scopes := extractScopes(claims)
+	if c.AnyRequiredScope && len(c.RequiredScopes) > 0 {
+		return slices.ContainsFunc(c.RequiredScopes, func(required string) bool { return slices.Contains(scopes, required) })
+	}
+	for _, required := range c.RequiredScopes {
+		if !slices.Contains(scopes, required) {
+			return false
+		}
+	}
+	return true
+}
 
-	metadata := oauthex.ProtectedResourceMetadata{
-		Resource:             c.ResourceURL,
-		ScopesSupported:      []string{"mcp:tools"},
-		AuthorizationServers: []string{c.AuthzServerURL},
+// authorizationServers returns the authorization servers to advertise in the metadata
+func (c *OAuthConfig) authorizationServers() []string {
+	if len(c.AuthorizationServers) == 0 {
+		return []string{c.AuthzServerURL}
+	}
+	return c.AuthorizationServers
+}
+
+// logSubject returns the subject as it should appear in logs
+func (c *OAuthConfig) logSubject(sub string) string {
+	if !c.HashLogSubjects {
+		return sub
+	}
+	// Salted hash keeps activity correlatable without exposing the raw identifier
+	sum := sha256.Sum256([]byte(c.LogSubjectSalt + sub))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// loggableClaims returns a copy of the claims with the subject prepared for logging
+func (c *OAuthConfig) loggableClaims(claims jwt.MapClaims) jwt.MapClaims {
+	copied := make(jwt.MapClaims, len(claims))
+	for k, v := range claims {
+		if !sensitiveClaims[k] {
+			copied[k] = v
+		}
+	}
+	if sub, ok := claims["sub"].(string); ok {
+		copied["sub"] = c.logSubject(sub)
+	}
+	return copied
+}
+
+// sensitiveClaims lists personal data claims that are never logged
+var sensitiveClaims = map[string]bool{
+	"email":              true,
+	"name":               true,
+	"given_name":         true,
+	"family_name":        true,
+	"preferred_username": true,
+	"phone_number":       true,
+	"address":            true,
+	"birthdate":          true,
+	"nonce":              true,
+	"session_state":      true,
+	"sid":                true,
+}
+
+// applyClaimHeaders sets the configured claim headers and strips the raw token for downstream consumers
+func (c *OAuthConfig) applyClaimHeaders(r *http.Request, claims jwt.MapClaims) {
+	for claim, header := range c.ClaimHeaders {
+		// Always drop client-supplied values so mapped headers cannot be spoofed
+		r.Header.Del(header)
+		switch v := claims[claim].(type) {
+		case nil:
+		case string:
+			r.Header.Set(header, v)
+		case []interface{}:
+			values := make([]string, 0, len(v))
+			for _, e := range v {
+				values = append(values, fmt.Sprint(e))
+			}
+			r.Header.Set(header, strings.Join(values, " "))
+		default:
+			r.Header.Set(header, fmt.Sprint(v))
+		}
+	}
+	if !c.ForwardAccessToken {
+		r.Header.Del("Authorization")
+	}
+}
+
+// clientID returns the OAuth client the token was issued to
+func clientID(claims jwt.MapClaims) string {
+	if id, ok := claims["client_id"].(string); ok {
+		return id
+	}
+	id, _ := claims["azp"].(string)
+	return id
+}
+
+// unverifiedClaims decodes the bearer token claims without verifying the signature
+func unverifiedClaims(r *http.Request) jwt.MapClaims {
+	claims := jwt.MapClaims{}
+	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if tokenString == "" {
+		return claims
+	}
+	jwt.NewParser().ParseUnverified(tokenString, claims)
+	return claims
+}
+
+// authErrorKind classifies an authorization failure for the RFC 6750 WWW-Authenticate error code
+type authErrorKind int
+
+const (
+	// authErrorMissingToken means the request carried no bearer token
+	authErrorMissingToken authErrorKind = iota
+	// authErrorInvalidToken means the token is expired, revoked, malformed or otherwise invalid
+	authErrorInvalidToken
+	// authErrorInsufficientScope means the token is valid but lacks the required scopes
+	authErrorInsufficientScope
+)
+
+// code returns the RFC 6750 error code, or "" when none should be sent
+func (k authErrorKind) code() string {
+	switch k {
+	case authErrorInvalidToken:
+		return "invalid_token"
+	case authErrorInsufficientScope:
+		return "insufficient_scope"
+	default:
+		return ""
+	}
+}
+
+// description returns the generic error_description used when verbose errors are off
+func (k authErrorKind) description() string {
+	if k == authErrorInsufficientScope {
+		return "The access token does not have the required scope"
+	}
+	return "The access token is invalid"
+}
+
+// sendUnauthorized sends a 401 (403 for insufficient scope) response with an RFC 6750 WWW-Authenticate header.
+// The reason is only included in the response when VerboseErrors is enabled.
+func (c *OAuthConfig) sendUnauthorized(w http.ResponseWriter, r *http.Request, kind authErrorKind, reason string) {
+	stats.authFailures.Add(1)
+	authTotal.WithLabelValues(kind.authOutcome()).Inc()
+	observeAuthDuration(r, kind.authOutcome())
+	recordDecision(r, "auth", "deny("+reason+")")
+	if c.SecurityLog != nil {
+		// The token was rejected, so its claims are unverified and only used for attribution
+		claims := unverifiedClaims(r)
+		sub, _ := claims["sub"].(string)
+		c.SecurityLog.Log(r, "deny", reason, c.logSubject(sub), clientID(claims))
+	}
+
+	status := http.StatusUnauthorized
+	message := "unauthorized"
+	if kind == authErrorInsufficientScope {
+		status = http.StatusForbidden
+		message = "forbidden"
+	}
+	description := kind.description()
+	if c.VerboseErrors {
+		message += ": " + reason
+		description = reason
+	}
+
+	// RFC 6750 Section 3: no error code when credentials are missing, so clients simply authenticate
+	metadataURL := c.ResourceURL + "/.well-known/oauth-protected-resource"
+	challenge := fmt.Sprintf(`Bearer resource_metadata="%s"`, metadataURL)
+	if code := kind.code(); code != "" {
+		// Quotes and backslashes are not allowed in error_description
+		description = strings.NewReplacer(`"`, "'", `\`, "/").Replace(description)
+		challenge += fmt.Sprintf(`, error="%s", error_description="%s"`, code, description)
+	}
+	if kind == authErrorInsufficientScope {
+		challenge += fmt.Sprintf(`, scope="%s"`, strings.Join(c.RequiredScopes, " "))
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	writeError(w, status, message)
+}
+
+// protectedResourceMetadata extends the RFC 9728 metadata with vendor extensions
+type protectedResourceMetadata struct {
+	oauthex.ProtectedResourceMetadata
+	// Tools lists the names of the available MCP tools (opt-in, disclosed before authentication)
+	Tools []string `json:"x_mcp_tools,omitempty"`
+}
+
+// HandleProtectedResourceMetadata handles the protected resource metadata endpoint; CORS headers are added by CORSMiddleware
+func (c *OAuthConfig) HandleProtectedResourceMetadata(w http.ResponseWriter, r *http.Request) {
+	metadata := protectedResourceMetadata{
+		ProtectedResourceMetadata: oauthex.ProtectedResourceMetadata{
+			Resource:             c.ResourceURL,
+			ScopesSupported:      c.RequiredScopes,
+			AuthorizationServers: c.authorizationServers(),
+		},
+		Tools: c.AdvertisedTools,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(metadata)
 }
 
+// maxCapturedBodyBytes caps how much of a request body is kept for the access log
+const maxCapturedBodyBytes = 64 << 10
+
+// MaxLoggedBodyBytes caps how much of a captured request body is written to the log
+var MaxLoggedBodyBytes = 4 << 10
+
+// logBodies enables logging of request bodies; tool arguments may contain secrets
+var logBodies = true
+
+// isJSONRequest reports whether the request body is JSON, the only bodies worth capturing
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// truncateBody shortens a body for logging, marking it with an ellipsis when cut
+func truncateBody(body []byte, truncated bool) string {
+	if len(body) > MaxLoggedBodyBytes {
+		body, truncated = body[:MaxLoggedBodyBytes], true
+	}
+	if truncated {
+		return string(body) + "..."
+	}
+	return string(body)
+}
+
+// cappedBuffer keeps the first max bytes written to it and discards the rest
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+// Write never fails so the tee does not interrupt the handler's read
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := b.max - b.buf.Len(); room < n {
+		b.truncated = true
+		p = p[:max(room, 0)]
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+// toolCallName returns the tool name of a JSON-RPC tools/call request body, or "" for any other message
+func toolCallName(body []byte) string {
+	var msg struct {
+		Method string `json:"method"`
+		Params struct {
+			Name string `json:"name"`
+		} `json:"params"`
+	}
+	if json.Unmarshal(body, &msg) != nil || msg.Method != "tools/call" {
+		return ""
+	}
+	return msg.Params.Name
+}
+
+// statusRecorder captures the response status for the access log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush keeps streaming responses working through the recorder
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 // LoggingMiddleware logs HTTP requests including method, path, and POST body
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+		stats.requests.Add(1)
+
+		recordDecision(r, "logging", "pass")
 
 		// Log basic request info
-		log.Printf("[%s] %s %s", r.Method, r.URL.Path, r.RemoteAddr)
+		logger.Debug("Request started", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
 
-		// Log POST body if present
-		if r.Method == "POST" && r.Body != nil {
-			bodyBytes, err := io.ReadAll(r.Body)
-			if err != nil {
-				log.Printf("Error reading body: %v", err)
-			} else {
-				// Log the body
-				log.Printf("Body: %s", string(bodyBytes))
-				// Restore the body for the next handler
-				r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-			}
+		// Tee the POST body into a capped log buffer as the handler reads it, instead of buffering it twice.
+		// The handler always receives the full body; only the logged copy is capped.
+		var logged *cappedBuffer
+		if r.Method == "POST" && r.Body != nil && isJSONRequest(r) {
+			logged = &cappedBuffer{max: maxCapturedBodyBytes}
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, logged), r.Body}
 		}
 
-		next.ServeHTTP(w, r)
+		timing := &requestTiming{}
+		r = r.WithContext(context.WithValue(r.Context(), requestTimingKey{}, timing))
 
-		log.Printf("Request completed in %v", time.Since(start))
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		var tool string
+		if logged != nil && logged.buf.Len() > 0 {
+			if logBodies {
+				logger.Debug("Request body", "body", truncateBody(logged.buf.Bytes(), logged.truncated))
+			}
+			if !logged.truncated {
+				tool = toolCallName(logged.buf.Bytes())
+			}
+		}
+		elapsed := time.Since(start)
+		attrs := []any{"method", r.Method, "path", r.URL.Path, "status", rec.status, "duration", elapsed,
+			"handler", timing.handler, "overhead", elapsed - timing.handler}
+		if tool != "" {
+			attrs = append(attrs, "tool", tool)
+		}
+		logger.Info("Request completed", attrs...)
 	})
 }
@@ -0,0 +1,1282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestValidateScope(t *testing.T) {
+	required := []string{"mcp:tools", "mcp:admin"}
+	tests := []struct {
+		name     string
+		matchAny bool
+		scope    string
+		want     bool
+	}{
+		{"all: every scope", false, "mcp:tools mcp:admin", true},
+		{"all: superset", false, "openid mcp:tools mcp:admin", true},
+		{"all: subset", false, "mcp:tools", false},
+		{"all: none", false, "openid", false},
+		{"all: empty", false, "", false},
+		{"any: every scope", true, "mcp:tools mcp:admin", true},
+		{"any: superset", true, "openid mcp:admin mcp:tools", true},
+		{"any: subset", true, "mcp:admin", true},
+		{"any: none", true, "openid", false},
+		{"any: empty", true, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &OAuthConfig{RequiredScopes: required, AnyRequiredScope: tt.matchAny}
+			if got := c.validateScope(jwt.MapClaims{"scope": tt.scope}); got != tt.want {
+				t.Errorf("validateScope(%q) = %v, want %v", tt.scope, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateScopeWithoutRequiredScopes(t *testing.T) {
+	for _, matchAny := range []bool{false, true} {
+		c := &OAuthConfig{AnyRequiredScope: matchAny}
+		if !c.validateScope(jwt.MapClaims{}) {
+			t.Errorf("validateScope with no required scopes (any=%v) = false, want true", matchAny)
+		}
+	}
+}
+
+func TestValidateScopeClaimForms(t *testing.T) {
+	c := &OAuthConfig{RequiredScopes: []string{"mcp:tools"}}
+	tests := []struct {
+		name  string
+		scope any
+		want  bool
+	}{
+		{"space-delimited string", "openid mcp:tools", true},
+		{"JSON array", []any{"openid", "mcp:tools"}, true},
+		{"JSON array without the scope", []any{"openid"}, false},
+		{"missing claim", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := jwt.MapClaims{}
+			if tt.scope != nil {
+				claims["scope"] = tt.scope
+			}
+			if got := c.validateScope(claims); got != tt.want {
+				t.Errorf("validateScope(%v) = %v, want %v", tt.scope, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOAuthMiddlewareWithoutRequiredScopes(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	c.RequiredScopes = nil
+	claims := validClaims()
+	delete(claims, "scope")
+	if rec, reached := authorize(c, key.mint(t, claims)); !reached {
+		t.Errorf("token without a scope claim rejected with status %d although no scopes are required", rec.Code)
+	}
+}
+
+func TestAlgNoneRejectedMetric(t *testing.T) {
+	c := &OAuthConfig{JwksURL: "http://127.0.0.1:0/jwks"}
+	handler := c.OAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler reached with an unsigned token")
+	}))
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "alice"}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := testutil.ToFloat64(algNoneRejectedTotal)
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if got := testutil.ToFloat64(algNoneRejectedTotal) - before; got != 1 {
+		t.Errorf("mcp_alg_none_rejected_total increased by %v, want 1", got)
+	}
+}
+
+func TestOAuthMiddlewareAcceptsValidToken(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	if rec, reached := authorize(c, key.mint(t, validClaims())); !reached {
+		t.Fatalf("valid token rejected with %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestWWWAuthenticateErrorCodes(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	noScope := validClaims()
+	noScope["scope"] = "openid"
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		code   string
+	}{
+		{"missing token", "", http.StatusUnauthorized, ""},
+		{"malformed token", "not-a-jwt", http.StatusUnauthorized, "invalid_token"},
+		{"expired token", key.mint(t, expired), http.StatusUnauthorized, "invalid_token"},
+		{"insufficient scope", key.mint(t, noScope), http.StatusForbidden, "insufficient_scope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, reached := authorize(c, tt.token)
+			if reached {
+				t.Fatal("request reached the handler")
+			}
+			assertAuthError(t, rec, tt.status, tt.code)
+			challenge := rec.Header().Get("WWW-Authenticate")
+			if !strings.Contains(challenge, `resource_metadata="`) {
+				t.Errorf("WWW-Authenticate = %q, want resource_metadata", challenge)
+			}
+			if tt.code != "" && !strings.Contains(challenge, `error_description="`) {
+				t.Errorf("WWW-Authenticate = %q, want error_description", challenge)
+			}
+			if tt.code == "insufficient_scope" && !strings.Contains(challenge, `scope="mcp:tools"`) {
+				t.Errorf("WWW-Authenticate = %q, want the required scopes", challenge)
+			}
+		})
+	}
+}
+
+func TestSubPattern(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	c.SubPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+	tests := []struct {
+		name     string
+		sub      any
+		accepted bool
+	}{
+		{"matching sub", "0f8fad5b-d9cb-469f-a165-70867728950e", true},
+		{"non-matching sub", "alice@example.com", false},
+		{"absent sub", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			if tt.sub == nil {
+				delete(claims, "sub")
+			} else {
+				claims["sub"] = tt.sub
+			}
+			rec, reached := authorize(c, key.mint(t, claims))
+			if reached != tt.accepted {
+				t.Fatalf("accepted = %v, want %v (status %d)", reached, tt.accepted, rec.Code)
+			}
+			if !tt.accepted {
+				assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+			}
+		})
+	}
+}
+
+func TestAudienceFromRequestURL(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	c.AudienceFromRequestURL = true
+	c.TrustProxyHeaders = true
+
+	tests := []struct {
+		name     string
+		aud      string
+		url      string
+		headers  map[string]string
+		accepted bool
+	}{
+		{"token for the requested URL", "http://localhost:8000/sse", "http://localhost:8000/sse", nil, true},
+		{"token for another path", "http://localhost:8000/", "http://localhost:8000/sse", nil, false},
+		{"token for the resource at the root", "http://localhost:8000/", "http://localhost:8000/", nil, true},
+		{"URL rebuilt from trusted proxy headers", "https://mcp.example.com/sse", "http://10.0.0.5:8000/sse",
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "mcp.example.com"}, false},
+		{"client-chosen host outside the configured audiences", "http://evil.example/", "http://evil.example/", nil, false},
+		{"forwarded host outside the configured audiences", "https://evil.example/", "http://localhost:8000/",
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			claims["aud"] = tt.aud
+			req := httptest.NewRequest(http.MethodPost, tt.url, nil)
+			req.Header.Set("Authorization", "Bearer "+key.mint(t, claims))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec, reached := authorizeRequest(c, req)
+			if reached != tt.accepted {
+				t.Fatalf("accepted = %v, want %v (status %d)", reached, tt.accepted, rec.Code)
+			}
+		})
+	}
+
+	// Behind a proxy, the public URL is a configured audience
+	c.AcceptedAudiences = []string{"https://mcp.example.com"}
+	claims := validClaims()
+	claims["aud"] = "https://mcp.example.com/sse"
+	req := httptest.NewRequest(http.MethodPost, "http://10.0.0.5:8000/sse", nil)
+	req.Header.Set("Authorization", "Bearer "+key.mint(t, claims))
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "mcp.example.com")
+	if rec, reached := authorizeRequest(c, req); !reached {
+		t.Errorf("token for the public URL rejected with %d", rec.Code)
+	}
+}
+
+func TestAlgNoneRejectedLog(t *testing.T) {
+	logs := captureLogs(t)
+	c := newTestOAuthConfig(t, newTestKey(t))
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := stats.algNoneRejected.Load()
+	rec, reached := authorize(c, token)
+	if reached {
+		t.Fatal("unsigned token accepted")
+	}
+	assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+	if !strings.Contains(logs.String(), "alg=none token rejected") {
+		t.Errorf("no alg=none security warning logged:\n%s", logs)
+	}
+	if got := stats.algNoneRejected.Load() - before; got != 1 {
+		t.Errorf("algNoneRejected increased by %d, want 1", got)
+	}
+}
+
+func TestDevToken(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	c.DevToken = "dev-secret"
+	c.DevSubject = "developer"
+	c.DevScopes = []string{"mcp:tools"}
+
+	var claims jwt.MapClaims
+	handler := c.OAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ = ClaimsFromContext(r.Context())
+	}))
+	serve := func(token string) *httptest.ResponseRecorder {
+		claims = nil
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve("dev-secret"); rec.Code != http.StatusOK || claims["sub"] != "developer" {
+		t.Errorf("dev token: status %d, sub %v; want accepted as developer", rec.Code, claims["sub"])
+	}
+
+	// Any other token goes through normal validation
+	if rec := serve("dev-secret2"); claims != nil {
+		t.Errorf("token differing from the dev token accepted (status %d)", rec.Code)
+	}
+	if rec := serve(key.mint(t, validClaims())); rec.Code != http.StatusOK || claims["sub"] != "alice" {
+		t.Errorf("valid JWT: status %d, sub %v; want accepted as alice", rec.Code, claims["sub"])
+	}
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	if rec := serve(key.mint(t, expired)); claims != nil {
+		t.Errorf("expired JWT accepted with a dev token configured (status %d)", rec.Code)
+	}
+}
+
+func TestDevTokenDisabledByDefault(t *testing.T) {
+	c := newTestOAuthConfig(t, newTestKey(t))
+	if _, reached := authorize(c, ""); reached {
+		t.Error("empty token accepted")
+	}
+	if _, reached := authorize(c, "dev-secret"); reached {
+		t.Error("arbitrary token accepted without -dev-token")
+	}
+}
+
+func TestAllowedKIDs(t *testing.T) {
+	key := newTestKey(t)
+	token := key.mint(t, validClaims())
+	tests := []struct {
+		name     string
+		allowed  []string
+		accepted bool
+	}{
+		{"no pinning", nil, true},
+		{"allowed kid", []string{"other-key", testKID}, true},
+		{"disallowed kid", []string{"other-key"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestOAuthConfig(t, key)
+			c.AllowedKIDs = tt.allowed
+			rec, reached := authorize(c, token)
+			if reached != tt.accepted {
+				t.Fatalf("accepted = %v, want %v (status %d)", reached, tt.accepted, rec.Code)
+			}
+			if !tt.accepted {
+				assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+			}
+		})
+	}
+}
+
+func TestExclusiveAudience(t *testing.T) {
+	key := newTestKey(t)
+	tests := []struct {
+		name      string
+		exclusive bool
+		aud       any
+		accepted  bool
+	}{
+		{"single audience", true, testResource, true},
+		{"single audience in an array", true, []string{testResource}, true},
+		{"extra audience", true, []string{testResource, "https://other.example"}, false},
+		{"extra audience without the flag", false, []string{testResource, "https://other.example"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestOAuthConfig(t, key)
+			c.ExclusiveAudience = tt.exclusive
+			claims := validClaims()
+			claims["aud"] = tt.aud
+			rec, reached := authorize(c, key.mint(t, claims))
+			if reached != tt.accepted {
+				t.Fatalf("accepted = %v, want %v (status %d)", reached, tt.accepted, rec.Code)
+			}
+			if !tt.accepted {
+				assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+			}
+		})
+	}
+}
+
+func TestJWKSFailureMode(t *testing.T) {
+	key := newTestKey(t)
+	token := key.mint(t, validClaims())
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	for _, tt := range []struct {
+		failOpen bool
+		accepted bool
+	}{
+		{false, false},
+		{true, true},
+	} {
+		c := &OAuthConfig{AuthzServerURL: testIssuer, JwksURL: unreachable.URL, ResourceURL: testResource, RequiredScopes: []string{"mcp:tools"}, LazyJWKS: true, JwksFailOpen: tt.failOpen}
+		t.Cleanup(c.Close)
+		rec, reached := authorize(c, token)
+		if reached != tt.accepted {
+			t.Errorf("failOpen=%v: accepted = %v, want %v (status %d)", tt.failOpen, reached, tt.accepted, rec.Code)
+		}
+		if !tt.accepted {
+			assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+		}
+	}
+}
+
+func TestJWKSFailOpenStillVerifiesSignatures(t *testing.T) {
+	c := newTestOAuthConfig(t, newTestKey(t))
+	c.JwksFailOpen = true
+	// The keys are available, so a token signed by another key is a bad signature, not an outage
+	rec, reached := authorize(c, newTestKey(t).mint(t, validClaims()))
+	if reached {
+		t.Fatal("token with a bad signature accepted in fail-open mode")
+	}
+	assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+}
+
+func TestIssuerJWKSCrossCheck(t *testing.T) {
+	const federatedIssuer = "https://idp-b.example"
+	// Both keys use testKID, so only the issuer can tell their key sets apart
+	keyA, keyB := newTestKey(t), newTestKey(t)
+	jwksA := httptest.NewServer(http.HandlerFunc(keyA.serveJWKS))
+	defer jwksA.Close()
+	jwksB := httptest.NewServer(http.HandlerFunc(keyB.serveJWKS))
+	defer jwksB.Close()
+	c := &OAuthConfig{AuthzServerURL: testIssuer, JwksURL: jwksA.URL, ResourceURL: testResource, IssuerJwksURLs: map[string]string{federatedIssuer: jwksB.URL}}
+	if err := c.InitJWKS(); err != nil {
+		t.Fatalf("InitJWKS: %v", err)
+	}
+	defer c.Close()
+
+	tests := []struct {
+		name     string
+		iss      string
+		key      *testKey
+		accepted bool
+	}{
+		{"issuer A signed by A", testIssuer, keyA, true},
+		{"issuer B signed by B", federatedIssuer, keyB, true},
+		{"issuer A signed by B", testIssuer, keyB, false},
+		{"issuer B signed by A", federatedIssuer, keyA, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			claims["iss"] = tt.iss
+			rec, reached := authorize(c, tt.key.mint(t, claims))
+			if reached != tt.accepted {
+				t.Fatalf("accepted = %v, want %v (status %d)", reached, tt.accepted, rec.Code)
+			}
+			if !tt.accepted {
+				assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+			}
+		})
+	}
+}
+
+func TestTrustedIssuers(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+
+	tests := []struct {
+		name     string
+		trusted  []string
+		iss      string
+		accepted bool
+	}{
+		{"default issuer", nil, testIssuer, true},
+		{"other issuer by default", nil, "https://idp-b.example", false},
+		{"first trusted issuer", []string{testIssuer, "https://idp-b.example"}, testIssuer, true},
+		{"second trusted issuer", []string{testIssuer, "https://idp-b.example"}, "https://idp-b.example", true},
+		{"untrusted issuer", []string{testIssuer, "https://idp-b.example"}, "https://idp-c.example", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.TrustedIssuers = tt.trusted
+			claims := validClaims()
+			claims["iss"] = tt.iss
+			rec, reached := authorize(c, key.mint(t, claims))
+			if reached != tt.accepted {
+				t.Fatalf("accepted = %v, want %v (status %d)", reached, tt.accepted, rec.Code)
+			}
+			if !tt.accepted {
+				assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+			}
+		})
+	}
+
+	// Issuers with their own JWKS are trusted without being listed
+	c.TrustedIssuers = nil
+	c.IssuerJwksURLs = map[string]string{"https://idp-b.example": "https://idp-b.example/certs"}
+	if got := c.trustedIssuers(); !slices.Equal(got, []string{testIssuer, "https://idp-b.example"}) {
+		t.Errorf("trustedIssuers = %v, want the default issuer and the JWKS issuer", got)
+	}
+}
+
+func TestRawTokenNeverLogged(t *testing.T) {
+	key := newTestKey(t)
+	claims := validClaims()
+	claims["email"] = "alice@example.com"
+	token := key.mint(t, claims)
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	expiredToken := key.mint(t, expired)
+
+	for _, debugAuth := range []bool{false, true} {
+		logs := captureLogs(t)
+		c := newTestOAuthConfig(t, key)
+		c.DebugAuth = debugAuth
+		if rec, reached := authorize(c, token); !reached {
+			t.Fatalf("debugAuth=%v: valid token rejected with status %d", debugAuth, rec.Code)
+		}
+		authorize(c, expiredToken)
+
+		for _, tok := range []string{token, expiredToken} {
+			signature := tok[strings.LastIndex(tok, ".")+1:]
+			if strings.Contains(logs.String(), tok) || strings.Contains(logs.String(), signature) {
+				t.Errorf("debugAuth=%v: the raw token or its signature appears in the logs:\n%s", debugAuth, logs)
+			}
+		}
+		if strings.Contains(logs.String(), "alice@example.com") {
+			t.Errorf("debugAuth=%v: a sensitive claim appears in the logs", debugAuth)
+		}
+		if dumped := strings.Contains(logs.String(), `"msg":"JWT access token"`); dumped != debugAuth {
+			t.Errorf("debugAuth=%v: token dump logged = %v", debugAuth, dumped)
+		}
+	}
+}
+
+// readAllRestoreMiddleware is the approach LoggingMiddleware replaced: the whole body is read for the log and
+// then restored for the handler, so it is buffered twice
+func readAllRestoreMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+		logger.Debug("Request body", "body", truncateBody(body, false))
+	})
+}
+
+// consumeBody is a handler reading the whole request body, as the MCP handler does
+func TestProtectedResourceMetadataListsAuthorizationServers(t *testing.T) {
+	metadata := func(c *OAuthConfig) []string {
+		rec := httptest.NewRecorder()
+		c.HandleProtectedResourceMetadata(rec, httptest.NewRequest(http.MethodGet, "/.well-known/oauth-protected-resource", nil))
+		var got struct {
+			AuthorizationServers []string `json:"authorization_servers"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("invalid metadata %q: %v", rec.Body.String(), err)
+		}
+		return got.AuthorizationServers
+	}
+
+	servers := []string{testIssuer, "https://idp.example.com"}
+	c := &OAuthConfig{AuthzServerURL: testIssuer, ResourceURL: testResource, AuthorizationServers: servers}
+	if got := metadata(c); !slices.Equal(got, servers) {
+		t.Errorf("authorization_servers = %v, want %v", got, servers)
+	}
+	// Without -authorization-servers, the issuer is the only authorization server
+	c.AuthorizationServers = nil
+	if got := metadata(c); !slices.Equal(got, []string{testIssuer}) {
+		t.Errorf("authorization_servers = %v, want [%s]", got, testIssuer)
+	}
+
+	// The challenge points clients at the metadata listing them all
+	rec, _ := authorize(newTestOAuthConfig(t, newTestKey(t)), "")
+	if want := `resource_metadata="` + testResource + `/.well-known/oauth-protected-resource"`; !strings.Contains(rec.Header().Get("WWW-Authenticate"), want) {
+		t.Errorf("WWW-Authenticate = %q, want %s", rec.Header().Get("WWW-Authenticate"), want)
+	}
+}
+
+func TestErrorVerbosity(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	claims := validClaims()
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	token := key.mint(t, claims)
+
+	for _, verbose := range []bool{false, true} {
+		logs := captureLogs(t)
+		c.VerboseErrors = verbose
+		rec, _ := authorize(c, token)
+		assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+
+		// Only verbose responses carry the specific reason
+		detailed := strings.Contains(rec.Body.String(), "expired") || strings.Contains(rec.Header().Get("WWW-Authenticate"), "expired")
+		if detailed != verbose {
+			t.Errorf("verbose=%v: body = %q, WWW-Authenticate = %q; the reason is disclosed: %v", verbose, rec.Body.String(), rec.Header().Get("WWW-Authenticate"), detailed)
+		}
+		// The logs always have it
+		if !strings.Contains(logs.String(), "token is expired") {
+			t.Errorf("verbose=%v: logs lack the failure reason:\n%s", verbose, logs)
+		}
+	}
+}
+
+func TestSessionClosedWhenTokenExpires(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	c.SessionExpiryGrace = 200 * time.Millisecond
+	claims := validClaims()
+	exp := time.Now().Add(1500 * time.Millisecond).Truncate(time.Second)
+	claims["exp"] = exp.Unix()
+
+	// The handler streams until the request is canceled
+	var cause error
+	var closedAt time.Time
+	handler := c.OAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		cause, closedAt = context.Cause(r.Context()), time.Now()
+	}))
+	req := httptest.NewRequest(http.MethodGet, testResource+"/", nil)
+	req.Header.Set("Authorization", "Bearer "+key.mint(t, claims))
+	req.Header.Set("Accept", "text/event-stream")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !errors.Is(cause, errTokenExpiredMidSession) {
+		t.Errorf("session closed with cause %v, want %v", cause, errTokenExpiredMidSession)
+	}
+	if closedAt.Before(exp.Add(c.SessionExpiryGrace)) {
+		t.Errorf("session closed at %v, before the token expiry %v plus the grace %v", closedAt, exp, c.SessionExpiryGrace)
+	}
+}
+
+func TestHashLogSubjects(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	c.HashLogSubjects = true
+	c.LogSubjectSalt = "pepper"
+	claims := validClaims()
+	claims["sub"] = "user-4711"
+
+	logs := captureLogs(t)
+	for range 2 {
+		if rec, reached := authorize(c, key.mint(t, claims)); !reached {
+			t.Fatalf("valid token rejected with status %d", rec.Code)
+		}
+	}
+	hashed := c.logSubject("user-4711")
+	if strings.Contains(logs.String(), "user-4711") {
+		t.Errorf("raw subject logged:\n%s", logs)
+	}
+	// The same subject always maps to the same hash, so its requests can still be correlated
+	if n := strings.Count(logs.String(), `"sub":"`+hashed+`"`); n != 2 {
+		t.Errorf("hashed subject %s logged %d times, want 2:\n%s", hashed, n, logs)
+	}
+
+	c.LogSubjectSalt = "salt"
+	if c.logSubject("user-4711") == hashed {
+		t.Error("hash does not depend on the salt")
+	}
+	c.HashLogSubjects = false
+	if got := c.logSubject("user-4711"); got != "user-4711" {
+		t.Errorf("logSubject with hashing disabled = %q, want the raw subject", got)
+	}
+}
+
+func TestAudienceIsAuthorizationServerDiagnostic(t *testing.T) {
+	const diagnostic = "Token audience is the authorization server, not this resource"
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+
+	for _, aud := range []any{testIssuer, []any{"account", testIssuer}} {
+		logs := captureLogs(t)
+		claims := validClaims()
+		claims["aud"] = aud
+		rec, reached := authorize(c, key.mint(t, claims))
+		if reached {
+			t.Fatalf("aud %v: token for the authorization server accepted", aud)
+		}
+		assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+		if !strings.Contains(logs.String(), diagnostic) {
+			t.Errorf("aud %v: logs lack the audience diagnostic:\n%s", aud, logs)
+		}
+	}
+
+	// Other audience mismatches get only the generic warning
+	logs := captureLogs(t)
+	claims := validClaims()
+	claims["aud"] = "https://other.example.com"
+	authorize(c, key.mint(t, claims))
+	if strings.Contains(logs.String(), diagnostic) {
+		t.Errorf("audience diagnostic logged for an unrelated audience:\n%s", logs)
+	}
+}
+
+func TestProtectedResourceMetadataAdvertisedTools(t *testing.T) {
+	metadata := func(c *OAuthConfig) map[string]any {
+		rec := httptest.NewRecorder()
+		c.HandleProtectedResourceMetadata(rec, httptest.NewRequest(http.MethodGet, "/.well-known/oauth-protected-resource", nil))
+		var got map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("invalid metadata %q: %v", rec.Body.String(), err)
+		}
+		return got
+	}
+
+	c := &OAuthConfig{AuthzServerURL: testIssuer, ResourceURL: testResource}
+	if tools, ok := metadata(c)["x_mcp_tools"]; ok {
+		t.Errorf("x_mcp_tools = %v by default, want it absent", tools)
+	}
+
+	// As with -advertise-tools-in-metadata, the names of the registered tools are listed
+	newServer(nil, false)
+	c.AdvertisedTools = toolNames
+	tools, _ := metadata(c)["x_mcp_tools"].([]any)
+	for _, name := range []string{"echo", "base64", "whoami"} {
+		if !slices.Contains(tools, any(name)) {
+			t.Errorf("x_mcp_tools = %v, want it to list %s", tools, name)
+		}
+	}
+}
+
+func TestUnknownKIDRefreshesJWKS(t *testing.T) {
+	key, rotated := newTestKey(t), newTestKey(t)
+	var fetches atomic.Int32
+	var serveRotated atomic.Bool
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if !serveRotated.Load() {
+			key.serveJWKS(w, r)
+			return
+		}
+		// The authorization server has rotated to a key with a new kid
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(strings.Replace(rotated.jwksJSON(), testKID, "rotated-key", 1)))
+	}))
+	defer jwksServer.Close()
+	c := &OAuthConfig{AuthzServerURL: testIssuer, JwksURL: jwksServer.URL, ResourceURL: testResource, RequiredScopes: []string{"mcp:tools"}}
+	if err := c.InitJWKS(); err != nil {
+		t.Fatalf("InitJWKS: %v", err)
+	}
+	defer c.Close()
+	initial := fetches.Load()
+
+	// A bad signature under a known kid is rejected without a refresh
+	authorize(c, rotated.mint(t, validClaims()))
+	if n := fetches.Load() - initial; n != 0 {
+		t.Errorf("bad signature triggered %d JWKS fetches, want 0", n)
+	}
+
+	serveRotated.Store(true)
+	if rec, reached := authorize(c, rotated.mintKID(t, "rotated-key", validClaims())); !reached {
+		t.Fatalf("token with the rotated kid rejected with status %d", rec.Code)
+	}
+	if n := fetches.Load() - initial; n != 1 {
+		t.Errorf("unknown kid triggered %d JWKS fetches, want 1", n)
+	}
+}
+
+func TestTokenHeaderDebugLog(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	c.JwksUnknownKIDRefreshInterval = time.Hour
+	token := key.mint(t, validClaims())
+
+	logs := captureLogs(t)
+	authorize(c, token)
+	if want := `"msg":"Token header","kid":"test-key","alg":"RS256","kid_in_jwks":true`; !strings.Contains(logs.String(), want) {
+		t.Errorf("debug logs lack %s:\n%s", want, logs)
+	}
+
+	// A kid missing from the JWKS is reported as such
+	logs.Reset()
+	authorize(c, key.mintKID(t, "unknown-key", validClaims()))
+	if want := `"msg":"Token header","kid":"unknown-key","alg":"RS256","kid_in_jwks":false`; !strings.Contains(logs.String(), want) {
+		t.Errorf("debug logs lack %s:\n%s", want, logs)
+	}
+
+	// Above the debug level, the header is not logged
+	var info bytes.Buffer
+	logger = slog.New(slog.NewJSONHandler(&info, nil))
+	authorize(c, token)
+	if strings.Contains(info.String(), "Token header") {
+		t.Errorf("token header logged at the info level:\n%s", info.String())
+	}
+}
+
+func TestClaimHeaders(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	c.ClaimHeaders = map[string]string{"sub": "X-User-Id", "groups": "X-User-Groups", "email": "X-User-Email"}
+	claims := validClaims()
+	claims["groups"] = []any{"users", "admins"}
+	claims["tenant"] = "acme"
+	token := key.mint(t, claims)
+
+	// forward sends a request with spoofed claim headers and returns the headers passed downstream
+	forward := func() http.Header {
+		var downstream http.Header
+		handler := c.OAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			downstream = r.Header
+		}))
+		req := httptest.NewRequest(http.MethodPost, testResource+"/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-User-Id", "mallory")
+		req.Header.Set("X-User-Email", "mallory@example.com")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if downstream == nil {
+			t.Fatal("valid token rejected")
+		}
+		return downstream
+	}
+
+	header := forward()
+	if got := header.Get("X-User-Id"); got != "alice" {
+		t.Errorf("X-User-Id = %q, want the sub claim", got)
+	}
+	if got := header.Get("X-User-Groups"); got != "users admins" {
+		t.Errorf("X-User-Groups = %q, want the groups claim", got)
+	}
+	// A mapped claim missing from the token drops the client's header instead of passing it on
+	if got := header.Get("X-User-Email"); got != "" {
+		t.Errorf("X-User-Email = %q, want no header for a claim the token lacks", got)
+	}
+	// Unmapped claims and the raw token are not forwarded
+	for name, values := range header {
+		for _, v := range values {
+			if strings.Contains(v, "acme") || strings.Contains(v, token) {
+				t.Errorf("%s = %q forwards an unmapped claim or the token", name, v)
+			}
+		}
+	}
+	if got := header.Get("Authorization"); got != "" {
+		t.Errorf("Authorization = %q, want it stripped", got)
+	}
+
+	c.ForwardAccessToken = true
+	if got := forward().Get("Authorization"); got != "Bearer "+token {
+		t.Errorf("Authorization with -forward-access-token = %q, want the token", got)
+	}
+}
+
+func TestScopesFromContext(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	want := []string{"openid", "mcp:tools", "mcp:whoami:claims"}
+
+	for _, scope := range []any{" openid  mcp:tools mcp:whoami:claims ", []any{"openid", "mcp:tools", "mcp:whoami:claims"}} {
+		claims := validClaims()
+		claims["scope"] = scope
+		var got []string
+		handler := c.OAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = ScopesFromContext(r.Context())
+		}))
+		req := httptest.NewRequest(http.MethodPost, testResource+"/", nil)
+		req.Header.Set("Authorization", "Bearer "+key.mint(t, claims))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if !slices.Equal(got, want) {
+			t.Errorf("scope %q: ScopesFromContext = %q, want %q", scope, got, want)
+		}
+	}
+
+	if got := ScopesFromContext(context.Background()); got != nil {
+		t.Errorf("ScopesFromContext without a token = %q, want nil", got)
+	}
+}
+
+func TestUnknownKIDRefreshIsLimited(t *testing.T) {
+	key := newTestKey(t)
+	var fetches atomic.Int32
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		// A slow JWKS endpoint keeps the refresh in flight while the other validations arrive
+		time.Sleep(50 * time.Millisecond)
+		key.serveJWKS(w, r)
+	}))
+	defer jwksServer.Close()
+	c := &OAuthConfig{AuthzServerURL: testIssuer, JwksURL: jwksServer.URL, ResourceURL: testResource, JwksUnknownKIDRefreshInterval: time.Hour}
+	if err := c.InitJWKS(); err != nil {
+		t.Fatalf("InitJWKS: %v", err)
+	}
+	defer c.Close()
+	initial := fetches.Load()
+
+	// A burst of tokens with unknown kids, some repeated, some distinct
+	var wg sync.WaitGroup
+	for i := range 50 {
+		token := key.mintKID(t, fmt.Sprintf("unknown-%d", i%5), validClaims())
+		wg.Go(func() {
+			if _, reached := authorize(c, token); reached {
+				t.Error("token with an unknown kid accepted")
+			}
+		})
+	}
+	wg.Wait()
+	if n := fetches.Load() - initial; n != 1 {
+		t.Errorf("%d JWKS refreshes for the burst, want 1", n)
+	}
+}
+
+func TestTokenVersion(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	c.TokenVersionClaim = "ver"
+
+	tests := []struct {
+		name     string
+		exact    string
+		min      float64
+		ver      any
+		accepted bool
+	}{
+		{"matching version", "2", 0, "2", true},
+		{"older version", "2", 0, "1", false},
+		{"absent claim", "2", 0, nil, false},
+		{"numeric version at the minimum", "", 2, float64(2), true},
+		{"numeric version above the minimum", "", 2, "2.1", true},
+		{"version below the minimum", "", 2, float64(1), false},
+		{"non-numeric version with a minimum", "", 2, "v2", false},
+		{"absent claim with a minimum", "", 2, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.TokenVersion, c.MinTokenVersion = tt.exact, tt.min
+			claims := validClaims()
+			if tt.ver != nil {
+				claims["ver"] = tt.ver
+			}
+			rec, reached := authorize(c, key.mint(t, claims))
+			if reached != tt.accepted {
+				t.Fatalf("accepted = %v, want %v", reached, tt.accepted)
+			}
+			if !tt.accepted {
+				assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+			}
+		})
+	}
+}
+
+func TestResourceURLAudienceForms(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	c.ResourceURL = "HTTPS://MCP.Example.com:443/mcp/"
+
+	tests := []struct {
+		aud      string
+		accepted bool
+	}{
+		{"HTTPS://MCP.Example.com:443/mcp/", true},
+		{"https://mcp.example.com/mcp", true},
+		{"https://mcp.example.com:8443/mcp", false},
+		{"https://other.example.com/mcp", false},
+	}
+	for _, tt := range tests {
+		claims := validClaims()
+		claims["aud"] = tt.aud
+		if _, reached := authorize(c, key.mint(t, claims)); reached != tt.accepted {
+			t.Errorf("aud %s: accepted = %v, want %v", tt.aud, reached, tt.accepted)
+		}
+	}
+}
+
+func TestAllowedAlgorithms(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, _ := json.Marshal(map[string]any{"keys": []any{map[string]string{
+		"kty": "EC",
+		"kid": "ec-key",
+		"use": "sig",
+		"alg": "ES256",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(ecKey.PublicKey.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(ecKey.PublicKey.Y.FillBytes(make([]byte, 32))),
+	}}})
+	es256 := jwt.NewWithClaims(jwt.SigningMethodES256, validClaims())
+	es256.Header["kid"] = "ec-key"
+	token, err := es256.SignedString(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		algorithms []string
+		accepted   bool
+	}{
+		{nil, false},
+		{[]string{"RS256"}, false},
+		{[]string{"RS256", "ES256"}, true},
+	} {
+		c := &OAuthConfig{AuthzServerURL: testIssuer, InlineJWKS: string(jwks), ResourceURL: testResource, AllowedAlgorithms: tt.algorithms}
+		if err := c.InitJWKS(); err != nil {
+			t.Fatalf("InitJWKS with %v: %v", tt.algorithms, err)
+		}
+		t.Cleanup(c.Close)
+		if _, reached := authorize(c, token); reached != tt.accepted {
+			t.Errorf("ES256 token with %v allowed: accepted = %v, want %v", tt.algorithms, reached, tt.accepted)
+		}
+	}
+
+	// Unknown algorithms and none are refused up front
+	for _, algorithms := range [][]string{{"RS256", "none"}, {"XS256"}} {
+		c := &OAuthConfig{AuthzServerURL: testIssuer, InlineJWKS: string(jwks), AllowedAlgorithms: algorithms}
+		if err := c.InitJWKS(); err == nil {
+			c.Close()
+			t.Errorf("InitJWKS with %v succeeded", algorithms)
+		}
+	}
+}
+
+func TestNonce(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	claims := validClaims()
+	claims["nonce"] = "n-0S6_WzA2Mj"
+	token := key.mint(t, claims)
+
+	tests := []struct {
+		name     string
+		header   string
+		nonce    string
+		accepted bool
+	}{
+		{"matching nonce", "X-Expected-Nonce", "n-0S6_WzA2Mj", true},
+		{"mismatching nonce", "X-Expected-Nonce", "n-other", false},
+		{"no nonce sent", "X-Expected-Nonce", "", true},
+		{"flag off", "", "n-other", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.NonceHeader = tt.header
+			req := httptest.NewRequest(http.MethodPost, testResource+"/", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			if tt.nonce != "" {
+				req.Header.Set("X-Expected-Nonce", tt.nonce)
+			}
+			rec, reached := authorizeRequest(c, req)
+			if reached != tt.accepted {
+				t.Fatalf("accepted = %v, want %v", reached, tt.accepted)
+			}
+			if !tt.accepted {
+				assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+			}
+		})
+	}
+}
+
+func TestFutureIssuedAt(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	c.ClockSkew = 30 * time.Second
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		iat      time.Time
+		accepted bool
+	}{
+		{"normal iat", now.Add(-time.Minute), true},
+		{"future iat within the skew", now.Add(10 * time.Second), true},
+		{"future iat beyond the skew", now.Add(5 * time.Minute), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			claims := validClaims()
+			claims["iat"] = tt.iat.Unix()
+			rec, reached := authorize(c, key.mint(t, claims))
+			if reached != tt.accepted {
+				t.Fatalf("accepted = %v, want %v", reached, tt.accepted)
+			}
+			if tt.accepted {
+				return
+			}
+			assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+			// The diagnostic is specific to iat, not the not-before check
+			if !strings.Contains(logs.String(), "token iat is in the future") || strings.Contains(logs.String(), "not yet valid") {
+				t.Errorf("logs lack the distinct iat diagnostic:\n%s", logs)
+			}
+		})
+	}
+
+	// A future nbf is reported as such, not as a future iat
+	logs := captureLogs(t)
+	claims := validClaims()
+	claims["nbf"] = now.Add(5 * time.Minute).Unix()
+	if _, reached := authorize(c, key.mint(t, claims)); reached {
+		t.Fatal("token with a future nbf accepted")
+	}
+	if strings.Contains(logs.String(), "token iat is in the future") {
+		t.Errorf("future nbf reported as a future iat:\n%s", logs)
+	}
+}
+
+func TestScopeAudienceRules(t *testing.T) {
+	const resourceX = "https://x.example"
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	c.ScopeAudienceRules = map[string]string{"resourceX:": resourceX}
+
+	tests := []struct {
+		name     string
+		scope    string
+		aud      []any
+		accepted bool
+	}{
+		{"consistent token", "mcp:tools resourceX:read", []any{testResource, resourceX}, true},
+		{"no scope implying a resource", "mcp:tools", []any{testResource}, true},
+		{"scope implying a resource missing from aud", "mcp:tools resourceX:read", []any{testResource}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			claims := validClaims()
+			claims["scope"], claims["aud"] = tt.scope, tt.aud
+			rec, reached := authorize(c, key.mint(t, claims))
+			if reached != tt.accepted {
+				t.Fatalf("accepted = %v, want %v (status %d)", reached, tt.accepted, rec.Code)
+			}
+			if tt.accepted {
+				return
+			}
+			assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+			if want := `"scope":"resourceX:read","implied_aud":"` + resourceX + `"`; !strings.Contains(logs.String(), want) {
+				t.Errorf("logs lack the diagnostic naming the scope and its audience:\n%s", logs)
+			}
+		})
+	}
+}
+
+func TestMaxAudiences(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	c.MaxAudiences = 3
+
+	tests := []struct {
+		name     string
+		aud      []any
+		accepted bool
+	}{
+		{"within the limit", []any{testResource, "https://a.example", "https://b.example"}, true},
+		{"exceeding the limit", []any{testResource, "https://a.example", "https://b.example", "https://c.example"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			claims := validClaims()
+			claims["aud"] = tt.aud
+			rec, reached := authorize(c, key.mint(t, claims))
+			if reached != tt.accepted {
+				t.Fatalf("accepted = %v, want %v (status %d)", reached, tt.accepted, rec.Code)
+			}
+			if tt.accepted {
+				return
+			}
+			assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+			if !strings.Contains(logs.String(), `"count":4,"max":3`) {
+				t.Errorf("logs lack the audience count diagnostic:\n%s", logs)
+			}
+		})
+	}
+}
+
+func TestDeprecatedKIDs(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	token := key.mint(t, validClaims())
+
+	logs := captureLogs(t)
+	authorize(c, token)
+	if strings.Contains(logs.String(), "deprecated key ID") {
+		t.Errorf("warning logged for a key ID that is not deprecated:\n%s", logs)
+	}
+
+	c.DeprecatedKIDs = []string{"old-key", testKID}
+	before := testutil.ToFloat64(deprecatedKIDTokensTotal.WithLabelValues(testKID))
+	logs.Reset()
+	if rec, reached := authorize(c, token); !reached {
+		t.Fatalf("token signed by a deprecated key ID rejected with status %d", rec.Code)
+	}
+	if want := `"level":"WARN","msg":"Token signed with deprecated key ID","kid":"test-key","sub":"alice"`; !strings.Contains(logs.String(), want) {
+		t.Errorf("logs lack %s:\n%s", want, logs)
+	}
+	if got := testutil.ToFloat64(deprecatedKIDTokensTotal.WithLabelValues(testKID)) - before; got != 1 {
+		t.Errorf("mcp_deprecated_kid_tokens_total{kid=%q} increased by %v, want 1", testKID, got)
+	}
+}
+
+// authDurationCount returns the number of mcp_auth_duration_seconds observations for the outcome
+func authDurationCount(t *testing.T, outcome string) uint64 {
+	t.Helper()
+	families, err := metricsRegistry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "mcp_auth_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if metric.GetLabel()[0].GetValue() == outcome {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
+func TestAuthDurationMetric(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	c.TokenCache = NewTokenCache(10)
+	token := key.mint(t, validClaims())
+	logs := captureLogs(t)
+
+	// The first request verifies the signature; the second is served from the token cache
+	for _, validation := range []string{"full validation", "cache hit"} {
+		logs.Reset()
+		before := authDurationCount(t, "success")
+		if rec, reached := authorize(c, token); !reached {
+			t.Fatalf("%s: token rejected with status %d", validation, rec.Code)
+		}
+		if got := authDurationCount(t, "success") - before; got != 1 {
+			t.Errorf("%s: success observations increased by %d, want 1", validation, got)
+		}
+		if cached := strings.Contains(logs.String(), "verification skipped (cached)"); cached != (validation == "cache hit") {
+			t.Errorf("%s: served from the token cache = %v", validation, cached)
+		}
+	}
+
+	before := authDurationCount(t, "invalid_token")
+	authorize(c, "not-a-jwt")
+	if got := authDurationCount(t, "invalid_token") - before; got != 1 {
+		t.Errorf("invalid_token observations increased by %d, want 1", got)
+	}
+}
+
+var consumeBody = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	io.ReadAll(r.Body)
+})
+
+// jsonBody returns a JSON-RPC request of about size bytes
+func jsonBody(size int) []byte {
+	return []byte(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo","arguments":{"message":"` + strings.Repeat("x", size) + `"}}}`)
+}
+
+func TestLoggingMiddlewarePassesFullBody(t *testing.T) {
+	captureLogs(t)
+	body := jsonBody(2 * maxCapturedBodyBytes)
+	var received []byte
+	handler := LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if !bytes.Equal(received, body) {
+		t.Errorf("handler received %d bytes, want the full %d", len(received), len(body))
+	}
+}
+
+func benchmarkBodyLogging(b *testing.B, middleware func(http.Handler) http.Handler) {
+	previous := logger
+	logger = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	b.Cleanup(func() { logger = previous })
+
+	body := jsonBody(1 << 20)
+	handler := middleware(consumeBody)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	for b.Loop() {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+// BenchmarkLoggingMiddlewareBody measures the tee into a capped log buffer; compare with
+// BenchmarkReadAllRestoreBody for the allocations saved on a 1 MiB body
+func BenchmarkLoggingMiddlewareBody(b *testing.B) {
+	benchmarkBodyLogging(b, LoggingMiddleware)
+}
+
+func BenchmarkReadAllRestoreBody(b *testing.B) {
+	benchmarkBodyLogging(b, readAllRestoreMiddleware)
+}
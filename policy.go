@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// policyRule allows or denies calls to a tool by callers whose token claims all match
+type policyRule struct {
+	// Tool is the tool name the rule applies to, or "*" for every tool
+	Tool string `json:"tool" yaml:"tool"`
+	// Claims maps claim names to the required value; "*" matches any value, and list claims match when they contain it
+	Claims map[string]string `json:"claims" yaml:"claims"`
+	// Effect is "allow" or "deny"
+	Effect string `json:"effect" yaml:"effect"`
+}
+
+// policyDocument is the parsed content of a policy file
+type policyDocument struct {
+	// Default is the effect when no rule matches: "allow" (when empty) or "deny"
+	Default string       `json:"default" yaml:"default"`
+	Rules   []policyRule `json:"rules" yaml:"rules"`
+}
+
+// ToolPolicy authorizes tool calls against the rules of a policy file, in addition to scope checks.
+// Rules are evaluated in order and the first matching rule decides.
+type ToolPolicy struct {
+	path string
+	doc  atomic.Pointer[policyDocument]
+}
+
+// toolPolicy, when set, is consulted by every tool registered with addTool
+var toolPolicy *ToolPolicy
+
+// NewToolPolicy loads the policy file at path
+func NewToolPolicy(path string) (*ToolPolicy, error) {
+	p := &ToolPolicy{path: path}
+	if err := p.Load(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Load parses and validates the policy file (YAML or JSON by extension) and swaps it in atomically
+func (p *ToolPolicy) Load() error {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	var doc policyDocument
+	switch ext := strings.ToLower(filepath.Ext(p.path)); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&doc)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &doc)
+	default:
+		return fmt.Errorf("unsupported policy file extension %q: use .yaml, .yml or .json", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse policy file: %w", err)
+	}
+
+	if doc.Default != "" && doc.Default != "allow" && doc.Default != "deny" {
+		return fmt.Errorf("invalid default %q: must be allow or deny", doc.Default)
+	}
+	for i, rule := range doc.Rules {
+		if rule.Tool == "" {
+			return fmt.Errorf("rule %d: tool is required", i+1)
+		}
+		if rule.Effect != "allow" && rule.Effect != "deny" {
+			return fmt.Errorf("rule %d: invalid effect %q: must be allow or deny", i+1, rule.Effect)
+		}
+	}
+
+	p.doc.Store(&doc)
+	logger.Info("Loaded policy rules", "count", len(doc.Rules), "file", p.path)
+	return nil
+}
+
+// Watch polls the policy file and reloads it when it changes, until ctx is done.
+// A file that fails validation is logged and the previous policy stays active.
+func (p *ToolPolicy) Watch(ctx context.Context, interval time.Duration) {
+	var lastMod time.Time
+	if fi, err := os.Stat(p.path); err == nil {
+		lastMod = fi.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fi, err := os.Stat(p.path)
+			if err != nil {
+				logger.Warn("Failed to stat policy file", "file", p.path, "error", err)
+				continue
+			}
+			if fi.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = fi.ModTime()
+			if err := p.Load(); err != nil {
+				logger.Warn("Keeping previous policy", "error", err)
+			}
+		}
+	}
+}
+
+// Allowed reports whether a caller with the claims may call the tool, and which rule decided
+func (p *ToolPolicy) Allowed(tool string, claims map[string]any) (bool, string) {
+	doc := p.doc.Load()
+	for i, rule := range doc.Rules {
+		if (rule.Tool == tool || rule.Tool == "*") && matchClaims(rule.Claims, claims) {
+			return rule.Effect == "allow", fmt.Sprintf("rule %d", i+1)
+		}
+	}
+	return doc.Default != "deny", "default"
+}
+
+// matchClaims reports whether the claims satisfy every required claim value
+func matchClaims(required map[string]string, claims map[string]any) bool {
+	for name, want := range required {
+		switch v := claims[name].(type) {
+		case nil:
+			return false
+		case string:
+			if want != "*" && v != want {
+				return false
+			}
+		case []any:
+			found := want == "*" && len(v) > 0
+			for _, item := range v {
+				if s, ok := item.(string); ok && s == want {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		default:
+			if want != "*" && fmt.Sprint(v) != want {
+				return false
+			}
+		}
+	}
+	return true
+}
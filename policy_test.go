@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePolicy writes a policy file named name with the content to a temporary directory
+func writePolicy(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// usePolicy installs the policy file until the test ends
+func usePolicy(t *testing.T, path string) *ToolPolicy {
+	t.Helper()
+	policy, err := NewToolPolicy(path)
+	if err != nil {
+		t.Fatalf("NewToolPolicy: %v", err)
+	}
+	toolPolicy = policy
+	t.Cleanup(func() { toolPolicy = nil })
+	return policy
+}
+
+func TestToolPolicy(t *testing.T) {
+	usePolicy(t, writePolicy(t, "policy.yaml", `
+default: allow
+rules:
+  - tool: base64
+    claims:
+      groups: admins
+    effect: allow
+  - tool: base64
+    effect: deny
+`))
+
+	tests := []struct {
+		name   string
+		tool   string
+		claims map[string]any
+		denied bool
+	}{
+		{"allow rule", "base64", map[string]any{"sub": "alice", "groups": []any{"users", "admins"}}, false},
+		{"deny rule", "base64", map[string]any{"sub": "bob", "groups": []any{"users"}}, true},
+		{"default", "echo", map[string]any{"sub": "bob"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			denial := toolCallDenial(tt.tool, nil, false, toolCall(nil, tt.claims))
+			if tt.denied != (denial != "") {
+				t.Fatalf("toolCallDenial = %q, want denied=%v", denial, tt.denied)
+			}
+			if tt.denied && !strings.Contains(denial, "denied by policy") {
+				t.Errorf("denial %q does not report the policy", denial)
+			}
+		})
+	}
+}
+
+func TestToolPolicyDefaultDeny(t *testing.T) {
+	usePolicy(t, writePolicy(t, "policy.json", `{"default": "deny", "rules": [{"tool": "*", "claims": {"sub": "alice"}, "effect": "allow"}]}`))
+
+	if denial := toolCallDenial("echo", nil, false, toolCall(nil, map[string]any{"sub": "alice"})); denial != "" {
+		t.Errorf("call allowed by a wildcard rule denied: %s", denial)
+	}
+	if denial := toolCallDenial("echo", nil, false, toolCall(nil, map[string]any{"sub": "bob"})); denial == "" {
+		t.Error("call matching no rule allowed under default deny")
+	}
+}
+
+func TestToolPolicyReloadKeepsPreviousOnError(t *testing.T) {
+	path := writePolicy(t, "policy.yaml", "default: deny\n")
+	policy := usePolicy(t, path)
+
+	if err := os.WriteFile(path, []byte("default: maybe\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.Load(); err == nil {
+		t.Fatal("invalid policy loaded")
+	}
+	if allowed, _ := policy.Allowed("echo", nil); allowed {
+		t.Error("previous default-deny policy was replaced by an invalid file")
+	}
+
+	if err := os.WriteFile(path, []byte("default: allow\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if allowed, _ := policy.Allowed("echo", nil); !allowed {
+		t.Error("reloaded default-allow policy not applied")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// NewPprofHandler returns the net/http/pprof handlers for the separate profiling server.
+// They are never mounted on the main port.
+func NewPprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// newPprofServerHandler returns the handler of the profiling server listening on addr.
+// A non-loopback address is only allowed with an admin token, which then protects the handlers.
+func newPprofServerHandler(addr, adminToken string) (http.Handler, error) {
+	loopback, err := isLoopbackAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if loopback {
+		return NewPprofHandler(), nil
+	}
+	if adminToken == "" {
+		return nil, fmt.Errorf("%s is not a loopback address; set -admin-token to protect it", addr)
+	}
+	return AdminMiddleware(adminToken, NewPprofHandler()), nil
+}
+
+// isLoopbackAddr reports whether a listen address binds only to the loopback interface
+func isLoopbackAddr(addr string) (bool, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false, fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	if host == "localhost" {
+		return true, nil
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback(), nil
+}
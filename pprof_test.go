@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// pprofStatus returns the status of a GET of the pprof index through handler, with the admin token if set
+func pprofStatus(handler http.Handler, adminToken string) int {
+	req := httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+	if adminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestPprofServerHandler(t *testing.T) {
+	// Bound to loopback, the profiles need no token
+	for _, addr := range []string{"127.0.0.1:6060", "[::1]:6060", "localhost:6060"} {
+		handler, err := newPprofServerHandler(addr, "")
+		if err != nil {
+			t.Fatalf("newPprofServerHandler(%q): %v", addr, err)
+		}
+		if code := pprofStatus(handler, ""); code != http.StatusOK {
+			t.Errorf("%s: /debug/pprof/ status = %d, want %d", addr, code, http.StatusOK)
+		}
+	}
+
+	// Elsewhere the admin token is required
+	if _, err := newPprofServerHandler("0.0.0.0:6060", ""); err == nil {
+		t.Error("non-loopback pprof address without an admin token accepted")
+	}
+	handler, err := newPprofServerHandler("0.0.0.0:6060", "s3cret")
+	if err != nil {
+		t.Fatalf("newPprofServerHandler with an admin token: %v", err)
+	}
+	if code := pprofStatus(handler, ""); code != http.StatusForbidden {
+		t.Errorf("non-loopback /debug/pprof/ without the token: status = %d, want %d", code, http.StatusForbidden)
+	}
+	if code := pprofStatus(handler, "s3cret"); code != http.StatusOK {
+		t.Errorf("non-loopback /debug/pprof/ with the token: status = %d, want %d", code, http.StatusOK)
+	}
+
+	if _, err := newPprofServerHandler("6060", ""); err == nil {
+		t.Error("invalid pprof address accepted")
+	}
+}
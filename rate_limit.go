@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// subjectLimiterIdle is how long a caller's limiter is kept after its last request
+const subjectLimiterIdle = 10 * time.Minute
+
+// subjectLimiter is the token bucket of a single caller
+type subjectLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// SubjectRateLimiter rate limits requests per authenticated subject, or per remote IP when there is no subject
+type SubjectRateLimiter struct {
+	limit     rate.Limit
+	burst     int
+	mu        sync.Mutex
+	limiters  map[string]*subjectLimiter // "sub:<sub>" or "ip:<addr>" -> limiter
+	lastSweep time.Time
+}
+
+// NewSubjectRateLimiter creates a limiter allowing perSecond requests per caller with bursts of up to burst
+func NewSubjectRateLimiter(perSecond float64, burst int) *SubjectRateLimiter {
+	return &SubjectRateLimiter{limit: rate.Limit(perSecond), burst: burst, limiters: make(map[string]*subjectLimiter), lastSweep: time.Now()}
+}
+
+// rateLimitStatus is the state of a caller's bucket after a request
+type rateLimitStatus struct {
+	delay     time.Duration // how long to wait before the request would be allowed; 0 when it was allowed
+	remaining int           // requests still available right away
+	reset     time.Duration // until the bucket is full again
+}
+
+// Middleware rejects requests over the caller's limit with 429 and Retry-After.
+// Every response carries X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset so clients can self-throttle.
+// It must run after OAuthMiddleware so the subject is available.
+func (l *SubjectRateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := ""
+		if claims, ok := ClaimsFromContext(r.Context()); ok {
+			if sub, _ := claims["sub"].(string); sub != "" {
+				key = "sub:" + sub
+			}
+		}
+		if key == "" {
+			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				ip = r.RemoteAddr
+			}
+			key = "ip:" + ip
+		}
+
+		status := l.reserve(key)
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(l.burst))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(status.remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(status.reset)))
+		if status.delay > 0 {
+			logger.Warn("Rejected request: rate limit exceeded", "method", r.Method, "path", r.URL.Path, "retry_after", status.delay)
+			recordDecision(r, "rate-limit", "deny")
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(status.delay)))
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+
+		recordDecision(r, "rate-limit", "pass")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ceilSeconds rounds d up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// reserve takes a request from the caller's bucket, reporting how long to wait when none is available
+func (l *SubjectRateLimiter) reserve(key string) rateLimitStatus {
+	now := time.Now()
+	l.mu.Lock()
+	// Drop callers that have gone idle so remote IPs and subjects do not accumulate
+	if now.Sub(l.lastSweep) > subjectLimiterIdle {
+		for k, entry := range l.limiters {
+			if now.Sub(entry.lastSeen) > subjectLimiterIdle {
+				delete(l.limiters, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	entry := l.limiters[key]
+	if entry == nil {
+		entry = &subjectLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[key] = entry
+	}
+	entry.lastSeen = now
+	l.mu.Unlock()
+
+	var status rateLimitStatus
+	reservation := entry.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		status.delay = delay
+	}
+	tokens := max(entry.limiter.TokensAt(now), 0)
+	status.remaining = int(tokens)
+	status.reset = time.Duration((float64(l.burst) - tokens) / float64(l.limit) * float64(time.Second))
+	return status
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestSubjectRateLimiterHeaders(t *testing.T) {
+	limiter := NewSubjectRateLimiter(0.1, 3)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i, wantRemaining := range []string{"2", "1", "0"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d", i+1, rec.Code, http.StatusOK)
+		}
+		if got := rec.Header().Get("X-RateLimit-Limit"); got != "3" {
+			t.Errorf("request %d: X-RateLimit-Limit = %q, want 3", i+1, got)
+		}
+		if got := rec.Header().Get("X-RateLimit-Remaining"); got != wantRemaining {
+			t.Errorf("request %d: X-RateLimit-Remaining = %q, want %s", i+1, got, wantRemaining)
+		}
+		if reset, err := strconv.Atoi(rec.Header().Get("X-RateLimit-Reset")); err != nil || reset <= 0 {
+			t.Errorf("request %d: X-RateLimit-Reset = %q, want a positive number of seconds", i+1, rec.Header().Get("X-RateLimit-Reset"))
+		}
+		if got := rec.Header().Get("Retry-After"); got != "" {
+			t.Errorf("request %d: Retry-After = %q on an allowed request", i+1, got)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status over the limit = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if got := rec.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("X-RateLimit-Remaining over the limit = %q, want 0", got)
+	}
+	if retry, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 10 {
+		t.Errorf("Retry-After = %q, want 1-10 seconds", rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestSubjectRateLimiterPerCaller(t *testing.T) {
+	limiter := NewSubjectRateLimiter(0.1, 1)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, addr := range []string{"192.0.2.1:1000", "192.0.2.2:1000"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = addr
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("first request from %s: status = %d, want %d", addr, rec.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestSubjectRateLimiterKeyedBySubject(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	limiter := NewSubjectRateLimiter(0.1, 1)
+	handler := c.OAuthMiddleware(limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	serve := func(sub, addr string) *httptest.ResponseRecorder {
+		claims := validClaims()
+		claims["sub"] = sub
+		req := httptest.NewRequest(http.MethodPost, testResource+"/", nil)
+		req.Header.Set("Authorization", "Bearer "+key.mint(t, claims))
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve("alice", "192.0.2.1:1000"); rec.Code != http.StatusOK {
+		t.Fatalf("first request by alice: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	// Subjects sharing an address have buckets of their own
+	if rec := serve("bob", "192.0.2.1:1000"); rec.Code != http.StatusOK {
+		t.Errorf("first request by bob from alice's address: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	// A subject's bucket follows it across addresses
+	rec := serve("alice", "192.0.2.2:1000")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request by alice from another address: status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After on a rate limited request")
+	}
+}
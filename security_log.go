@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// SecurityLogger writes one JSON record per authorization decision, separate from the application log
+type SecurityLogger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// securityEvent is a single authorization decision record
+type securityEvent struct {
+	Time      string `json:"time"`
+	Decision  string `json:"decision"`
+	Reason    string `json:"reason,omitempty"`
+	Subject   string `json:"subject,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	RemoteIP  string `json:"remote_ip"`
+	RequestID string `json:"request_id,omitempty"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+}
+
+// NewSecurityLogger creates a security logger writing to w
+func NewSecurityLogger(w io.Writer) *SecurityLogger {
+	return &SecurityLogger{enc: json.NewEncoder(w)}
+}
+
+// OpenSecurityLog opens the security log destination: "stdout", "stderr", or a file path (appended to)
+func OpenSecurityLog(dest string) (*SecurityLogger, error) {
+	switch dest {
+	case "stdout":
+		return NewSecurityLogger(os.Stdout), nil
+	case "stderr":
+		return NewSecurityLogger(os.Stderr), nil
+	}
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open security log: %w", err)
+	}
+	return NewSecurityLogger(f), nil
+}
+
+// Log records an authorization decision for the request
+func (l *SecurityLogger) Log(r *http.Request, decision, reason, subject, clientID string) {
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+	event := securityEvent{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Decision:  decision,
+		Reason:    reason,
+		Subject:   subject,
+		ClientID:  clientID,
+		RemoteIP:  remoteIP,
+		RequestID: r.Header.Get("X-Request-Id"),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.enc.Encode(event)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// securityEvents decodes the records written to a security log
+func securityEvents(t *testing.T, data []byte) []securityEvent {
+	t.Helper()
+	var events []securityEvent
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var event securityEvent
+		if err := dec.Decode(&event); err != nil {
+			t.Fatalf("invalid security log record: %v\n%s", err, data)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestSecurityLogRecordsDenies(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	var buf bytes.Buffer
+	c.SecurityLog = NewSecurityLogger(&buf)
+
+	claims := validClaims()
+	claims["azp"] = "demo-client"
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	req := httptest.NewRequest(http.MethodPost, testResource+"/", nil)
+	req.Header.Set("Authorization", "Bearer "+key.mint(t, claims))
+	req.Header.Set("X-Request-Id", "req-1")
+	req.RemoteAddr = "192.0.2.10:54321"
+	if _, reached := authorizeRequest(c, req); reached {
+		t.Fatal("expired token accepted")
+	}
+
+	events := securityEvents(t, buf.Bytes())
+	if len(events) != 1 {
+		t.Fatalf("%d security log records, want 1:\n%s", len(events), buf.String())
+	}
+	event := events[0]
+	if event.Decision != "deny" || event.Reason == "" {
+		t.Errorf("decision = %q, reason = %q; want a deny with its reason", event.Decision, event.Reason)
+	}
+	if event.Subject != "alice" || event.ClientID != "demo-client" || event.RemoteIP != "192.0.2.10" || event.RequestID != "req-1" {
+		t.Errorf("record = %+v, want the subject, client id, remote ip and request id of the request", event)
+	}
+
+	// Allows are recorded as well
+	buf.Reset()
+	if rec, reached := authorize(c, key.mint(t, validClaims())); !reached {
+		t.Fatalf("valid token rejected with status %d", rec.Code)
+	}
+	if events := securityEvents(t, buf.Bytes()); len(events) != 1 || events[0].Decision != "allow" {
+		t.Errorf("security log after a valid token:\n%s\nwant one allow record", buf.String())
+	}
+}
+
+func TestOpenSecurityLogAppendsToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "security.log")
+	if err := os.WriteFile(path, []byte(`{"decision":"allow"}`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := OpenSecurityLog(path)
+	if err != nil {
+		t.Fatalf("OpenSecurityLog: %v", err)
+	}
+	l.Log(httptest.NewRequest(http.MethodPost, "/", nil), "deny", "missing token", "", "")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events := securityEvents(t, data); len(events) != 2 || events[1].Decision != "deny" {
+		t.Errorf("security log = %s, want the deny appended to the existing record", data)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+
+	"github.com/modelcontextprotocol/go-sdk/auth"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// SessionTokenMiddleware passes the token that opened an SSE session to the session's tool calls.
+// The SSE transport does not fill in req.Extra, so without it tools would see no caller at all.
+func SessionTokenMiddleware(tokenInfo *auth.TokenInfo) mcp.Middleware {
+	return func(next mcp.MethodHandler) mcp.MethodHandler {
+		return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+			if call, ok := req.(*mcp.CallToolRequest); ok && tokenInfo != nil && (call.Extra == nil || call.Extra.TokenInfo == nil) {
+				call.Extra = &mcp.RequestExtra{TokenInfo: tokenInfo}
+			}
+			return next(ctx, method, req)
+		}
+	}
+}
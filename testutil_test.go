@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testKID is the key ID of the test signing key
+const testKID = "test-key"
+
+// testKey is an RSA signing key shared by the tests
+type testKey struct {
+	private *rsa.PrivateKey
+}
+
+// newTestKey generates a signing key
+func newTestKey(t testing.TB) *testKey {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return &testKey{private: private}
+}
+
+// jwksJSON returns the JWK Set holding the public key
+func (k *testKey) jwksJSON() string {
+	jwk := map[string]string{
+		"kty": "RSA",
+		"kid": testKID,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(k.private.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.private.E)).Bytes()),
+	}
+	data, _ := json.Marshal(map[string]any{"keys": []any{jwk}})
+	return string(data)
+}
+
+// serveJWKS serves the JWK Set
+func (k *testKey) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(k.jwksJSON()))
+}
+
+// mint signs a token with the claims
+func (k *testKey) mint(t testing.TB, claims jwt.MapClaims) string {
+	t.Helper()
+	return k.mintKID(t, testKID, claims)
+}
+
+// mintKID signs a token with the claims whose header names the key ID kid
+func (k *testKey) mintKID(t testing.TB, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(k.private)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+const (
+	// testIssuer is the authorization server of the test tokens
+	testIssuer = "http://localhost/realms/demo"
+	// testResource is the resource URL of the test server
+	testResource = "http://localhost:8000"
+)
+
+// newTestOAuthConfig returns a config validating tokens signed by key, whose JWKS is served by a test server
+func newTestOAuthConfig(t testing.TB, key *testKey) *OAuthConfig {
+	t.Helper()
+	jwksServer := httptest.NewServer(http.HandlerFunc(key.serveJWKS))
+	t.Cleanup(jwksServer.Close)
+	c := &OAuthConfig{AuthzServerURL: testIssuer, JwksURL: jwksServer.URL, ResourceURL: testResource, RequiredScopes: []string{"mcp:tools"}}
+	if err := c.InitJWKS(); err != nil {
+		t.Fatalf("InitJWKS: %v", err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// validClaims returns claims that newTestOAuthConfig accepts
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":   testIssuer,
+		"aud":   testResource,
+		"sub":   "alice",
+		"scope": "openid mcp:tools",
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+}
+
+// authorize sends a request with the bearer token through c.OAuthMiddleware, reporting whether it reached the handler
+func authorize(c *OAuthConfig, token string) (*httptest.ResponseRecorder, bool) {
+	req := httptest.NewRequest(http.MethodPost, testResource+"/", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return authorizeRequest(c, req)
+}
+
+// authorizeRequest sends the request through c.OAuthMiddleware, reporting whether it reached the handler
+func authorizeRequest(c *OAuthConfig, req *http.Request) (*httptest.ResponseRecorder, bool) {
+	reached := false
+	handler := c.OAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec, reached
+}
+
+// assertAuthError checks the status and the RFC 6750 error code in WWW-Authenticate ("" for none)
+func assertAuthError(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if rec.Code != status {
+		t.Errorf("status = %d, want %d", rec.Code, status)
+	}
+	challenge := rec.Header().Get("WWW-Authenticate")
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		t.Errorf("WWW-Authenticate = %q, want a Bearer challenge", challenge)
+	}
+	hasError := strings.Contains(challenge, `error="`)
+	if code == "" && hasError {
+		t.Errorf("WWW-Authenticate = %q, want no error code", challenge)
+	}
+	if code != "" && !strings.Contains(challenge, `error="`+code+`"`) {
+		t.Errorf("WWW-Authenticate = %q, want error=%q", challenge, code)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// errRequestTimeout is the cancellation cause of a request that ran past its timeout
+var errRequestTimeout = errors.New("request timed out")
+
+// isStreamingRequest reports whether the request opens a long-lived SSE stream (GET) for server-initiated messages,
+// as opposed to a POST carrying JSON-RPC messages that is expected to complete quickly
+func isStreamingRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// timeoutWriter discards the handler's response once the request has timed out before anything was written,
+// so TimeoutMiddleware can answer with 503 instead
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	if w.discard() {
+		return
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	if w.discard() {
+		return len(b), nil
+	}
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// discard reports whether the response is being replaced by the timeout error
+func (w *timeoutWriter) discard() bool {
+	if !w.wroteHeader && errors.Is(context.Cause(w.ctx), errRequestTimeout) {
+		w.timedOut = true
+	}
+	return w.timedOut
+}
+
+// Flush keeps streaming responses working through the writer
+func (w *timeoutWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.timedOut {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// TimeoutMiddleware bounds each request with its own deadline: streamingTimeout for streaming sessions and
+// requestTimeout for everything else, except on exemptPaths. Zero leaves that kind of request unbounded.
+// Per-request deadlines are used instead of the server's WriteTimeout, which would apply the same limit to both.
+// A request that times out before its response has started gets 503; a response already being streamed is cut off.
+func TimeoutMiddleware(requestTimeout, streamingTimeout time.Duration, exemptPaths []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := requestTimeout
+		if isStreamingRequest(r) {
+			timeout = streamingTimeout
+		} else if slices.Contains(exemptPaths, r.URL.Path) {
+			timeout = 0
+		}
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeoutCause(r.Context(), timeout, errRequestTimeout)
+		defer cancel()
+		stop := context.AfterFunc(ctx, func() {
+			if errors.Is(context.Cause(ctx), errRequestTimeout) {
+				logger.Warn("Closing request: timeout exceeded", "method", r.Method, "path", r.URL.Path, "timeout", timeout)
+			}
+		})
+		defer stop()
+
+		header := w.Header().Clone()
+		tw := &timeoutWriter{ResponseWriter: w, ctx: ctx}
+		next.ServeHTTP(tw, r.WithContext(ctx))
+
+		if tw.timedOut || (!tw.wroteHeader && errors.Is(context.Cause(ctx), errRequestTimeout)) {
+			// Headers set by the handler (e.g. a new session ID) do not belong to the error response
+			clear(w.Header())
+			maps.Copy(w.Header(), header)
+			recordDecision(r, "timeout", "deny")
+			writeError(w, http.StatusServiceUnavailable, "request timed out")
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// slowHandler responds after delay, or gives up when the request is canceled first
+func slowHandler(delay time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+			w.Write([]byte("done"))
+		case <-r.Context().Done():
+			w.Write([]byte("canceled"))
+		}
+	})
+}
+
+func TestTimeoutMiddlewareSlowRequest(t *testing.T) {
+	handler := TimeoutMiddleware(50*time.Millisecond, 0, nil, slowHandler(time.Second))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if body := rec.Body.String(); body == "canceled" || body == "done" {
+		t.Errorf("body = %q, want the timeout error instead of the handler's response", body)
+	}
+}
+
+func TestTimeoutMiddlewareStreamingOutlivesRequestTimeout(t *testing.T) {
+	// The stream outlives -request-timeout and is bounded by -streaming-timeout only
+	handler := TimeoutMiddleware(50*time.Millisecond, time.Minute, nil, slowHandler(200*time.Millisecond))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "done" {
+		t.Errorf("stream: status = %d, body = %q, want %d and %q", rec.Code, rec.Body.String(), http.StatusOK, "done")
+	}
+}
+
+func TestTimeoutMiddlewareExemptPath(t *testing.T) {
+	handler := TimeoutMiddleware(50*time.Millisecond, 0, []string{"/slow"}, slowHandler(200*time.Millisecond))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/slow", nil))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "done" {
+		t.Errorf("exempt path: status = %d, body = %q, want %d and %q", rec.Code, rec.Body.String(), http.StatusOK, "done")
+	}
+}
+
+func TestTimeoutMiddlewareJSONError(t *testing.T) {
+	handler := TimeoutMiddleware(50*time.Millisecond, 0, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Mcp-Session-Id", "abc")
+		<-r.Context().Done()
+		w.Write([]byte("canceled"))
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status = %d, Content-Type = %q, want %d with JSON", rec.Code, rec.Header().Get("Content-Type"), http.StatusServiceUnavailable)
+	}
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error.Message != "request timed out" {
+		t.Errorf("body = %q, want a JSON error saying the request timed out", rec.Body.String())
+	}
+	// The handler's headers belong to the discarded response
+	if got := rec.Header().Get("Mcp-Session-Id"); got != "" {
+		t.Errorf("Mcp-Session-Id = %q on the timeout response, want none", got)
+	}
+}
+
+func TestTimeoutMiddlewareStartedResponse(t *testing.T) {
+	// A response already written when the deadline passes is not replaced by the timeout error
+	handler := TimeoutMiddleware(50*time.Millisecond, 0, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		<-r.Context().Done()
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "partial" {
+		t.Errorf("status = %d, body = %q, want %d and the started response", rec.Code, rec.Body.String(), http.StatusOK)
+	}
+}
+
+func TestTimeoutMiddlewareFastRequest(t *testing.T) {
+	for _, requestTimeout := range []time.Duration{time.Second, 0} {
+		handler := TimeoutMiddleware(requestTimeout, 0, nil, slowHandler(10*time.Millisecond))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+
+		if rec.Code != http.StatusOK || rec.Body.String() != "done" {
+			t.Errorf("timeout %v: status = %d, body = %q, want %d and %q", requestTimeout, rec.Code, rec.Body.String(), http.StatusOK, "done")
+		}
+	}
+}
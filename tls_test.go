@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClientCert issues a client certificate from a new CA, returning the CA pool and the certificate
+func newTestClientCert(t *testing.T) (*x509.CertPool, tls.Certificate) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "admin"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, caCert, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	return pool, tls.Certificate{Certificate: [][]byte{clientDER}, PrivateKey: clientKey}
+}
+
+func TestClientCertMiddleware(t *testing.T) {
+	clientCAs, clientCert := newTestClientCert(t)
+	handler := ClientCertMiddleware([]string{"/admin"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv := httptest.NewUnstartedServer(handler)
+	srv.TLS = &tls.Config{ClientCAs: clientCAs, ClientAuth: tls.VerifyClientCertIfGiven}
+	srv.StartTLS()
+	defer srv.Close()
+
+	withoutCert := srv.Client()
+	withCert := &http.Client{Transport: withoutCert.Transport.(*http.Transport).Clone()}
+	withCert.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{clientCert}
+
+	tests := []struct {
+		name   string
+		client *http.Client
+		path   string
+		want   int
+	}{
+		{"admin path with certificate", withCert, "/admin/diagnostics", http.StatusOK},
+		{"admin path without certificate", withoutCert, "/admin/diagnostics", http.StatusForbidden},
+		{"admin prefix itself without certificate", withoutCert, "/admin", http.StatusForbidden},
+		{"MCP path without certificate", withoutCert, "/", http.StatusOK},
+		{"path sharing the prefix without certificate", withoutCert, "/administrator", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := tt.client.Get(srv.URL + tt.path)
+			if err != nil {
+				t.Fatalf("GET %s: %v", tt.path, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("GET %s: status = %d, want %d", tt.path, resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientCertMiddlewareRejectsPlainHTTP(t *testing.T) {
+	handler := ClientCertMiddleware([]string{"/admin"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/maintenance", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+// certThumbprint returns the RFC 8705 x5t#S256 thumbprint of the certificate
+func certThumbprint(cert tls.Certificate) string {
+	sum := sha256.Sum256(cert.Certificate[0])
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestCertBoundTokens(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	c.CertBoundTokens = true
+	clientCAs, clientCert := newTestClientCert(t)
+	_, otherCert := newTestClientCert(t)
+
+	srv := httptest.NewUnstartedServer(c.OAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	srv.TLS = &tls.Config{ClientCAs: clientCAs, ClientAuth: tls.VerifyClientCertIfGiven}
+	srv.StartTLS()
+	defer srv.Close()
+	withoutCert := srv.Client()
+	withCert := &http.Client{Transport: withoutCert.Transport.(*http.Transport).Clone()}
+	withCert.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{clientCert}
+
+	boundTo := func(cert *tls.Certificate) string {
+		claims := validClaims()
+		if cert != nil {
+			claims["cnf"] = map[string]any{"x5t#S256": certThumbprint(*cert)}
+		}
+		return key.mint(t, claims)
+	}
+	tests := []struct {
+		name   string
+		client *http.Client
+		token  string
+		want   int
+	}{
+		{"bound to the connection's certificate", withCert, boundTo(&clientCert), http.StatusOK},
+		{"bound to another certificate", withCert, boundTo(&otherCert), http.StatusUnauthorized},
+		{"not bound", withCert, boundTo(nil), http.StatusUnauthorized},
+		{"bound but presented without a certificate", withoutCert, boundTo(&clientCert), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, srv.URL+"/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			resp, err := tt.client.Do(req)
+			if err != nil {
+				t.Fatalf("POST: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && !strings.Contains(resp.Header.Get("WWW-Authenticate"), `error="invalid_token"`) {
+				t.Errorf("WWW-Authenticate = %q, want invalid_token", resp.Header.Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
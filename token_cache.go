@@ -0,0 +1,72 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokenCache is an LRU cache of tokens whose signature has been verified, so repeated requests with the
+// same token skip signature verification. Entries are dropped once the token's exp has passed.
+type TokenCache struct {
+	size    int
+	mu      sync.Mutex
+	order   *list.List                 // most recently used first
+	entries map[[32]byte]*list.Element // token hash -> element holding a *tokenCacheEntry
+}
+
+// tokenCacheEntry is the verified claims of a cached token
+type tokenCacheEntry struct {
+	key    [32]byte
+	claims jwt.MapClaims
+	exp    time.Time
+}
+
+// NewTokenCache creates a cache holding up to size tokens
+func NewTokenCache(size int) *TokenCache {
+	return &TokenCache{size: size, order: list.New(), entries: make(map[[32]byte]*list.Element)}
+}
+
+// Get returns the cached claims of the token, or false when it is not cached or has expired
+func (c *TokenCache) Get(tokenString string) (jwt.MapClaims, bool) {
+	key := sha256.Sum256([]byte(tokenString))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*tokenCacheEntry)
+	if !time.Now().Before(entry.exp) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.claims, true
+}
+
+// Add caches the verified claims of the token, evicting the least recently used token when full.
+// Tokens without exp are not cached, since nothing would bound how long they are served from the cache.
+func (c *TokenCache) Add(tokenString string, claims jwt.MapClaims) {
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil || !time.Now().Before(exp.Time) {
+		return
+	}
+	key := sha256.Sum256([]byte(tokenString))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&tokenCacheEntry{key: key, claims: claims, exp: exp.Time})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*tokenCacheEntry).key)
+	}
+}
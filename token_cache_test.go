@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestTokenCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewTokenCache(2)
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	c.Add("a", jwt.MapClaims{"sub": "a", "exp": exp})
+	c.Add("b", jwt.MapClaims{"sub": "b", "exp": exp})
+	c.Get("a")
+	c.Add("c", jwt.MapClaims{"sub": "c", "exp": exp})
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("least recently used token b is still cached")
+	}
+	for _, token := range []string{"a", "c"} {
+		if claims, ok := c.Get(token); !ok || claims["sub"] != token {
+			t.Errorf("Get(%q) = %v, %v; want its claims", token, claims, ok)
+		}
+	}
+}
+
+func TestTokenCacheSkipsTokensWithoutExp(t *testing.T) {
+	c := NewTokenCache(1)
+	c.Add("a", jwt.MapClaims{"sub": "a"})
+	if _, ok := c.Get("a"); ok {
+		t.Error("token without exp was cached")
+	}
+}
+
+func TestTokenCacheDoesNotServeExpiredTokens(t *testing.T) {
+	c := NewTokenCache(1)
+	exp := time.Now().Add(1500 * time.Millisecond).Truncate(time.Second)
+	c.Add("a", jwt.MapClaims{"sub": "a", "exp": float64(exp.Unix())})
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("token is not cached before its exp")
+	}
+	time.Sleep(time.Until(exp) + 10*time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Error("token served from the cache after its exp")
+	}
+}
+
+func TestOAuthMiddlewareRejectsCachedTokenAfterExp(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	c.TokenCache = NewTokenCache(10)
+	claims := validClaims()
+	exp := time.Now().Add(1500 * time.Millisecond).Truncate(time.Second)
+	claims["exp"] = exp.Unix()
+	token := key.mint(t, claims)
+
+	if rec, reached := authorize(c, token); !reached {
+		t.Fatalf("token rejected before its exp with status %d", rec.Code)
+	}
+	if _, ok := c.TokenCache.Get(token); !ok {
+		t.Fatal("verified token was not cached")
+	}
+
+	time.Sleep(time.Until(exp) + 10*time.Millisecond)
+	rec, reached := authorize(c, token)
+	if reached {
+		t.Fatal("token accepted after its exp")
+	}
+	assertAuthError(t, rec, http.StatusUnauthorized, "invalid_token")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// toolRateLimit is the configured call rate of a single tool
+type toolRateLimit struct {
+	limit rate.Limit
+	burst int
+}
+
+// ToolRateLimiter rate limits tool calls per tool, and optionally per subject.
+// Over HTTP it is enforced by Middleware before dispatch, so a call over the limit gets 429 and Retry-After.
+type ToolRateLimiter struct {
+	limits     map[string]toolRateLimit
+	perSubject bool
+	mu         sync.Mutex
+	limiters   map[string]*subjectLimiter // tool (and subject) -> limiter
+	lastSweep  time.Time
+}
+
+// toolRateLimiter, when set, is consulted by every tool registered with addTool
+var toolRateLimiter *ToolRateLimiter
+
+// ParseToolRateLimits parses a comma-separated list of tool=N/unit entries (unit: s, m or h), e.g. "base64=10/m"
+func ParseToolRateLimits(spec string) (map[string]toolRateLimit, error) {
+	limits := make(map[string]toolRateLimit)
+	for _, entry := range splitList(spec) {
+		name, value, ok := strings.Cut(entry, "=")
+		count, unit, ok2 := strings.Cut(value, "/")
+		n, err := strconv.Atoi(count)
+		if !ok || !ok2 || err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid tool rate limit %q: expected tool=N/unit", entry)
+		}
+		per, ok := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}[unit]
+		if !ok {
+			return nil, fmt.Errorf("invalid tool rate limit %q: unit must be s, m or h", entry)
+		}
+		limits[strings.TrimSpace(name)] = toolRateLimit{limit: rate.Limit(float64(n) / per.Seconds()), burst: n}
+	}
+	return limits, nil
+}
+
+// NewToolRateLimiter creates a limiter enforcing the given per-tool limits; tools without a limit are unrestricted
+func NewToolRateLimiter(limits map[string]toolRateLimit, perSubject bool) *ToolRateLimiter {
+	return &ToolRateLimiter{limits: limits, perSubject: perSubject, limiters: make(map[string]*subjectLimiter), lastSweep: time.Now()}
+}
+
+// Reserve takes a call to the tool by sub from its limiter, returning how long to wait when none is available.
+// It is checked for every call, so each call in a JSON-RPC batch counts. Tools without a limit always pass.
+func (l *ToolRateLimiter) Reserve(tool, sub string) time.Duration {
+	limit, limited := l.limits[tool]
+	if !limited {
+		return 0
+	}
+	key := tool
+	if l.perSubject {
+		key += "\x00" + sub
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	// Drop limiters that have gone idle so per-subject limiters do not accumulate
+	if now.Sub(l.lastSweep) > subjectLimiterIdle {
+		for k, entry := range l.limiters {
+			if now.Sub(entry.lastSeen) > subjectLimiterIdle {
+				delete(l.limiters, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	entry := l.limiters[key]
+	if entry == nil {
+		entry = &subjectLimiter{limiter: rate.NewLimiter(limit.limit, limit.burst)}
+		l.limiters[key] = entry
+	}
+	entry.lastSeen = now
+	l.mu.Unlock()
+
+	reservation := entry.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return delay
+	}
+	return 0
+}
+
+// Middleware rejects a JSON-RPC request calling a tool over its limit with 429 and Retry-After; requests calling
+// only unrestricted tools pass. Every tools/call in a batch counts. It must run after OAuthMiddleware so the
+// subject is available.
+func (l *ToolRateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Body == nil || !isJSONRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The tools are only known from the body, which the handler then reads again
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var sub string
+		if claims, ok := ClaimsFromContext(r.Context()); ok {
+			sub, _ = claims["sub"].(string)
+		}
+		for _, name := range toolCallNames(body) {
+			if delay := l.Reserve(name, sub); delay > 0 {
+				logger.Warn("Rejected tool call: rate limit exceeded", "tool", name, "retry_after", delay.Round(time.Second))
+				recordDecision(r, "tool-rate-limit", "deny")
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(delay)))
+				writeError(w, http.StatusTooManyRequests, fmt.Sprintf("rate limit exceeded for the %s tool", name))
+				return
+			}
+		}
+
+		recordDecision(r, "tool-rate-limit", "pass")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// toolCallNames returns the tool names of the tools/call requests in a JSON-RPC message or batch
+func toolCallNames(body []byte) []string {
+	body = bytes.TrimSpace(body)
+	if !bytes.HasPrefix(body, []byte("[")) {
+		if name := toolCallName(body); name != "" {
+			return []string{name}
+		}
+		return nil
+	}
+	var batch []json.RawMessage
+	if json.Unmarshal(body, &batch) != nil {
+		return nil
+	}
+	var names []string
+	for _, msg := range batch {
+		if name := toolCallName(msg); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
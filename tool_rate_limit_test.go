@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// useToolRateLimiter installs a limiter for the spec until the test ends
+func useToolRateLimiter(t *testing.T, spec string, perSubject bool) {
+	t.Helper()
+	limits, err := ParseToolRateLimits(spec)
+	if err != nil {
+		t.Fatalf("ParseToolRateLimits(%q): %v", spec, err)
+	}
+	toolRateLimiter = NewToolRateLimiter(limits, perSubject)
+	t.Cleanup(func() { toolRateLimiter = nil })
+}
+
+func TestParseToolRateLimits(t *testing.T) {
+	limits, err := ParseToolRateLimits("base64=10/m, echo=2/s")
+	if err != nil {
+		t.Fatalf("ParseToolRateLimits: %v", err)
+	}
+	if got := limits["base64"]; got.burst != 10 {
+		t.Errorf("base64 burst = %d, want 10", got.burst)
+	}
+	if got := limits["echo"]; got.burst != 2 || got.limit != 2 {
+		t.Errorf("echo limit = %v/s burst %d, want 2/s burst 2", got.limit, got.burst)
+	}
+	for _, spec := range []string{"base64", "base64=10", "base64=0/m", "base64=10/d"} {
+		if _, err := ParseToolRateLimits(spec); err == nil {
+			t.Errorf("ParseToolRateLimits(%q) succeeded", spec)
+		}
+	}
+}
+
+// callTools posts a JSON-RPC request calling the tools (a batch with several) as sub through the limiter,
+// reporting whether it reached the handler
+func callTools(t *testing.T, sub string, tools ...string) (*httptest.ResponseRecorder, bool) {
+	t.Helper()
+	var calls []string
+	for i, tool := range tools {
+		calls = append(calls, fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"tools/call","params":{"name":%q,"arguments":{}}}`, i+1, tool))
+	}
+	body := strings.Join(calls, ",")
+	if len(calls) > 1 {
+		body = "[" + body + "]"
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), claimsContextKey{}, jwt.MapClaims{"sub": sub}))
+
+	reached := false
+	handler := toolRateLimiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		// The handler still reads the whole request
+		if got, _ := io.ReadAll(r.Body); string(got) != body {
+			t.Errorf("handler read %q, want %q", got, body)
+		}
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec, reached
+}
+
+// assertToolRateLimited calls the tools as sub and checks the request is rejected with 429 and a Retry-After
+func assertToolRateLimited(t *testing.T, sub string, tools ...string) {
+	t.Helper()
+	rec, reached := callTools(t, sub, tools...)
+	if reached || rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d (reached handler: %v), want %d", rec.Code, reached, http.StatusTooManyRequests)
+	}
+	if retry, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || retry < 1 {
+		t.Errorf("Retry-After = %q, want a positive number of seconds", rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestToolRateLimit(t *testing.T) {
+	useToolRateLimiter(t, "base64=2/m", false)
+
+	for i := range 2 {
+		if rec, reached := callTools(t, "alice", "base64"); !reached {
+			t.Fatalf("call %d within the limit rejected with status %d", i+1, rec.Code)
+		}
+	}
+	assertToolRateLimited(t, "alice", "base64")
+
+	// Tools without a limit stay callable
+	for range 5 {
+		if rec, reached := callTools(t, "alice", "echo"); !reached {
+			t.Fatalf("unrestricted tool rejected with status %d", rec.Code)
+		}
+	}
+}
+
+func TestToolRateLimitCountsBatchCalls(t *testing.T) {
+	useToolRateLimiter(t, "base64=2/m", false)
+	if rec, reached := callTools(t, "alice", "echo", "base64", "base64"); !reached {
+		t.Fatalf("batch within the limit rejected with status %d", rec.Code)
+	}
+	assertToolRateLimited(t, "alice", "echo", "base64")
+}
+
+func TestToolRateLimitPerSubject(t *testing.T) {
+	useToolRateLimiter(t, "base64=1/m", true)
+
+	if rec, reached := callTools(t, "alice", "base64"); !reached {
+		t.Fatalf("first call by alice rejected with status %d", rec.Code)
+	}
+	assertToolRateLimited(t, "alice", "base64")
+	if rec, reached := callTools(t, "bob", "base64"); !reached {
+		t.Errorf("first call by bob rejected with status %d", rec.Code)
+	}
+}
+
+func TestToolRateLimitOverStdio(t *testing.T) {
+	useToolRateLimiter(t, "base64=1/m", false)
+
+	if denial := toolCallDenial("base64", nil, true, &mcp.CallToolRequest{}); denial != "" {
+		t.Fatalf("first call denied: %s", denial)
+	}
+	denial := toolCallDenial("base64", nil, true, &mcp.CallToolRequest{})
+	if !strings.Contains(denial, "Rate limit exceeded") || !strings.Contains(denial, "retry after") {
+		t.Errorf("call over the limit: denial = %q, want a rate limit message with the retry delay", denial)
+	}
+	if denial := toolCallDenial("echo", nil, true, &mcp.CallToolRequest{}); denial != "" {
+		t.Errorf("unrestricted tool denied: %s", denial)
+	}
+}
+
+func TestToolRateLimiterDropsIdleLimiters(t *testing.T) {
+	l := NewToolRateLimiter(map[string]toolRateLimit{"base64": {limit: 1, burst: 1}}, true)
+	l.Reserve("base64", "alice")
+
+	// Age alice's limiter and the last sweep past the idle period
+	l.limiters["base64\x00alice"].lastSeen = time.Now().Add(-2 * subjectLimiterIdle)
+	l.lastSweep = time.Now().Add(-2 * subjectLimiterIdle)
+	l.Reserve("base64", "bob")
+	if _, ok := l.limiters["base64\x00alice"]; ok {
+		t.Error("idle limiter was not dropped")
+	}
+	if len(l.limiters) != 1 {
+		t.Errorf("%d limiters kept, want 1", len(l.limiters))
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// decisionTrace records the outcome of each middleware a request passed through, in order
+type decisionTrace struct {
+	mu    sync.Mutex
+	steps []string
+}
+
+// decisionTraceKey is the context key for the request's decision trace
+type decisionTraceKey struct{}
+
+// recordDecision appends a middleware outcome to the request's decision trace, if tracing is enabled
+func recordDecision(r *http.Request, stage, outcome string) {
+	trace, ok := r.Context().Value(decisionTraceKey{}).(*decisionTrace)
+	if !ok {
+		return
+	}
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	trace.steps = append(trace.steps, stage+"="+outcome)
+}
+
+// DecisionTraceMiddleware collects the decision trace of each request and logs it as a single entry on completion.
+// It must be the outermost middleware so every later stage can record into the trace.
+func DecisionTraceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace := &decisionTrace{}
+		r = r.WithContext(context.WithValue(r.Context(), decisionTraceKey{}, trace))
+
+		next.ServeHTTP(w, r)
+
+		trace.mu.Lock()
+		defer trace.mu.Unlock()
+		logger.Debug("Decision trace", "method", r.Method, "path", r.URL.Path, "steps", trace.steps)
+	})
+}
+
+// TraceHandler records that the request reached the final handler
+func TraceHandler(stage string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recordDecision(r, stage, "served")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestTiming records how long the MCP handler took, so the access log can separate it from auth overhead
+type requestTiming struct {
+	handler time.Duration
+}
+
+// requestTimingKey is the context key for the request's timing
+type requestTimingKey struct{}
+
+// TimingHandler measures the latency of the final handler for LoggingMiddleware
+func TimingHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		if timing, ok := r.Context().Value(requestTimingKey{}).(*requestTiming); ok {
+			timing.handler = time.Since(start)
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLogs sends the package logger's entries at debug level and above to the returned buffer until the test ends
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := logger
+	logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	t.Cleanup(func() { logger = previous })
+	return &buf
+}
+
+// decisionTraceSteps returns the steps of the logged decision trace
+func decisionTraceSteps(t *testing.T, logs *bytes.Buffer) []string {
+	t.Helper()
+	for line := range strings.SplitSeq(logs.String(), "\n") {
+		var entry struct {
+			Msg   string   `json:"msg"`
+			Level string   `json:"level"`
+			Steps []string `json:"steps"`
+		}
+		if json.Unmarshal([]byte(line), &entry) == nil && entry.Msg == "Decision trace" {
+			if entry.Level != "DEBUG" {
+				t.Errorf("decision trace logged at %s, want DEBUG", entry.Level)
+			}
+			return entry.Steps
+		}
+	}
+	t.Fatalf("no decision trace logged:\n%s", logs)
+	return nil
+}
+
+func TestDecisionTraceStopsAtAuthRejection(t *testing.T) {
+	logs := captureLogs(t)
+	c := &OAuthConfig{JwksURL: "http://127.0.0.1:0/jwks"}
+	handler := DecisionTraceMiddleware(LoggingMiddleware(c.OAuthMiddleware(TraceHandler("handler", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler reached without a token")
+	})))))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	steps := decisionTraceSteps(t, logs)
+	if len(steps) == 0 || !strings.HasPrefix(steps[len(steps)-1], "auth=deny") {
+		t.Errorf("steps = %v, want the chain to end with the auth rejection", steps)
+	}
+	if slices.Contains(steps, "handler=served") {
+		t.Errorf("steps = %v include the handler", steps)
+	}
+}
+
+func TestDecisionTraceReachesHandler(t *testing.T) {
+	logs := captureLogs(t)
+	handler := DecisionTraceMiddleware(TraceHandler("handler", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if steps := decisionTraceSteps(t, logs); !slices.Equal(steps, []string{"handler=served"}) {
+		t.Errorf("steps = %v, want [handler=served]", steps)
+	}
+}
+
+// requestCompleted returns the access log entry of the last completed request
+func requestCompleted(t *testing.T, logs *bytes.Buffer) map[string]any {
+	t.Helper()
+	var entry map[string]any
+	for line := range strings.SplitSeq(logs.String(), "\n") {
+		var e map[string]any
+		if json.Unmarshal([]byte(line), &e) == nil && e["msg"] == "Request completed" {
+			entry = e
+		}
+	}
+	if entry == nil {
+		t.Fatalf("no access log entry:\n%s", logs)
+	}
+	return entry
+}
+
+func TestAccessLogToolLatency(t *testing.T) {
+	key := newTestKey(t)
+	c := newTestOAuthConfig(t, key)
+	const delay = 30 * time.Millisecond
+	handler := LoggingMiddleware(c.OAuthMiddleware(TimingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		time.Sleep(delay)
+	}))))
+	send := func(body string) map[string]any {
+		logs := captureLogs(t)
+		req := httptest.NewRequest(http.MethodPost, testResource+"/", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key.mint(t, validClaims()))
+		req.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return requestCompleted(t, logs)
+	}
+
+	entry := send(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo","arguments":{"message":"hi"}}}`)
+	if entry["tool"] != "echo" {
+		t.Errorf("tool = %v, want echo", entry["tool"])
+	}
+	// The handler latency is reported apart from the auth overhead
+	handlerLatency, _ := entry["handler"].(float64)
+	overhead, ok := entry["overhead"].(float64)
+	if time.Duration(handlerLatency) < delay || !ok {
+		t.Errorf("handler = %v, overhead = %v; want the handler latency of at least %v and the overhead", entry["handler"], entry["overhead"], delay)
+	}
+	if duration, _ := entry["duration"].(float64); time.Duration(duration) != time.Duration(handlerLatency)+time.Duration(overhead) {
+		t.Errorf("duration = %v, want handler + overhead", entry["duration"])
+	}
+
+	// Other messages are logged without a tool
+	if entry := send(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`); entry["tool"] != nil || entry["handler"] == nil {
+		t.Errorf("initialize: entry = %v, want the handler latency and no tool", entry)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// warmupRetryInterval is how long warmup waits before retrying a failed fetch
+const warmupRetryInterval = time.Second
+
+// warmingUp keeps /readyz at 503 until startup warmup has finished
+var warmingUp atomic.Bool
+
+// StartWarmup fetches in the background everything the first requests would otherwise wait for: the JWKS and
+// the JWKS of every federated issuer (except with LazyJWKS, which defers them to the first token), and the
+// authorization server metadata when asMetadata is set. /readyz reports 503 from now until warmup succeeds or timeout passes; failed fetches are retried until then.
+func (c *OAuthConfig) StartWarmup(ctx context.Context, timeout time.Duration, asMetadata *ASMetadataProxy) <-chan error {
+	warmingUp.Store(true)
+	done := make(chan error, 1)
+	go func() {
+		defer warmingUp.Store(false)
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		err := c.warmup(ctx, asMetadata)
+		if err != nil {
+			logger.Warn("Warmup did not complete, readiness now depends on the JWKS alone", "timeout", timeout, "error", err)
+		} else {
+			logger.Info("Warmup complete", "duration", time.Since(start))
+		}
+		done <- err
+	}()
+	return done
+}
+
+// warmup retries warmupOnce until it succeeds or ctx is done.
+// A fetch that does not return (e.g. an unresponsive JWKS endpoint) cannot hold warmup past ctx.
+func (c *OAuthConfig) warmup(ctx context.Context, asMetadata *ASMetadataProxy) error {
+	result := make(chan error, 1)
+	go func() {
+		for {
+			err := c.warmupOnce(ctx, asMetadata)
+			if err == nil || ctx.Err() != nil {
+				result <- err
+				return
+			}
+			logger.Debug("Retrying warmup", "error", err)
+			select {
+			case <-ctx.Done():
+				result <- err
+				return
+			case <-time.After(warmupRetryInterval):
+			}
+		}
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// warmupOnce fetches the key sets and metadata once, dropping key sets that came back empty so the next attempt
+// fetches them again instead of waiting for the background refresh
+func (c *OAuthConfig) warmupOnce(ctx context.Context, asMetadata *ASMetadataProxy) error {
+	if c.hasJWKS() && !c.deferredJWKS() {
+		jwks, err := c.loadJWKS()
+		if err != nil {
+			return err
+		}
+		if keys, err := jwks.Storage().KeyReadAll(ctx); err != nil || len(keys) == 0 {
+			c.resetJWKS()
+			return errors.New("JWKS has no keys")
+		}
+		for iss, issuerJWKS := range c.issuerJWKSSnapshot() {
+			if keys, err := issuerJWKS.Storage().KeyReadAll(ctx); err != nil || len(keys) == 0 {
+				c.resetJWKS()
+				return fmt.Errorf("JWKS for issuer %s has no keys", iss)
+			}
+		}
+	}
+	if asMetadata != nil {
+		if _, err := asMetadata.current(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
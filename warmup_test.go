@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadyzDuringWarmup(t *testing.T) {
+	key := newTestKey(t)
+	var available atomic.Bool
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		key.serveJWKS(w, r)
+	}))
+	defer jwksServer.Close()
+
+	c := &OAuthConfig{JwksURL: jwksServer.URL}
+	defer c.Close()
+	done := c.StartWarmup(context.Background(), 10*time.Second, nil)
+
+	readyz := func() int {
+		rec := httptest.NewRecorder()
+		c.HandleReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec.Code
+	}
+	if code := readyz(); code != http.StatusServiceUnavailable {
+		t.Fatalf("/readyz during warmup = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+
+	// The first fetches failed; warmup keeps retrying until the JWKS is served
+	available.Store(true)
+	if err := <-done; err != nil {
+		t.Fatalf("warmup failed: %v", err)
+	}
+	if code := readyz(); code != http.StatusOK {
+		t.Errorf("/readyz after warmup = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestWarmupTimeout(t *testing.T) {
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer jwksServer.Close()
+
+	c := &OAuthConfig{JwksURL: jwksServer.URL}
+	defer c.Close()
+	if err := <-c.StartWarmup(context.Background(), 100*time.Millisecond, nil); err == nil {
+		t.Fatal("warmup against an unavailable JWKS succeeded")
+	}
+	if warmingUp.Load() {
+		t.Error("still warming up after the warmup timeout")
+	}
+}
+
+func TestLazyJWKSFetchedOnFirstToken(t *testing.T) {
+	key := newTestKey(t)
+	var fetches atomic.Int32
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		key.serveJWKS(w, r)
+	}))
+	defer jwksServer.Close()
+
+	c := &OAuthConfig{AuthzServerURL: testIssuer, JwksURL: jwksServer.URL, ResourceURL: testResource, LazyJWKS: true}
+	defer c.Close()
+	if err := c.InitJWKS(); err != nil {
+		t.Fatalf("InitJWKS: %v", err)
+	}
+	if err := <-c.StartWarmup(context.Background(), 10*time.Second, nil); err != nil {
+		t.Fatalf("warmup: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	c.HandleReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("/readyz before the first token = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if n := fetches.Load(); n != 0 {
+		t.Fatalf("JWKS fetched %d times before the first token, want 0", n)
+	}
+
+	token := key.mint(t, validClaims())
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			if rec, reached := authorize(c, token); !reached {
+				t.Errorf("first request rejected with status %d", rec.Code)
+			}
+		})
+	}
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("JWKS fetched %d times by concurrent first requests, want 1", n)
+	}
+}
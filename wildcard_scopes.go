@@ -0,0 +1,33 @@
+package main
+
+import (
+	"slices"
+)
+
+// WildcardScopeGuard refuses sensitive tools to tokens carrying an overly broad wildcard scope (e.g. "*" or "mcp:*")
+type WildcardScopeGuard struct {
+	dangerousScopes []string
+	sensitiveTools  []string
+}
+
+// wildcardScopeGuard, when set, is consulted by every tool registered with addTool
+var wildcardScopeGuard *WildcardScopeGuard
+
+// NewWildcardScopeGuard creates a guard refusing sensitiveTools to tokens holding any of dangerousScopes
+func NewWildcardScopeGuard(dangerousScopes, sensitiveTools []string) *WildcardScopeGuard {
+	return &WildcardScopeGuard{dangerousScopes: dangerousScopes, sensitiveTools: sensitiveTools}
+}
+
+// BroadScope returns the dangerous scope that disqualifies a token with scopes from calling tool, or "" when the
+// call may proceed. It is checked for every call, so each call in a JSON-RPC batch is covered.
+func (g *WildcardScopeGuard) BroadScope(tool string, scopes []string) string {
+	if !slices.Contains(g.sensitiveTools, tool) {
+		return ""
+	}
+	for _, scope := range scopes {
+		if slices.Contains(g.dangerousScopes, scope) {
+			return scope
+		}
+	}
+	return ""
+}
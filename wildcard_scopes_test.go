@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWildcardScopeGuard(t *testing.T) {
+	wildcardScopeGuard = NewWildcardScopeGuard([]string{"*", "mcp:*"}, []string{"admin"})
+	t.Cleanup(func() { wildcardScopeGuard = nil })
+
+	tests := []struct {
+		name   string
+		tool   string
+		scopes []string
+		denied bool
+	}{
+		{"broad scope on sensitive tool", "admin", []string{"openid", "mcp:*"}, true},
+		{"catch-all scope on sensitive tool", "admin", []string{"*"}, true},
+		{"narrow scope on sensitive tool", "admin", []string{"mcp:tools"}, false},
+		{"broad scope on benign tool", "echo", []string{"mcp:*"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			denial := toolCallDenial(tt.tool, nil, false, toolCall(tt.scopes, nil))
+			if tt.denied != (denial != "") {
+				t.Fatalf("toolCallDenial = %q, want denied=%v", denial, tt.denied)
+			}
+			if tt.denied && !strings.Contains(denial, "too broad") {
+				t.Errorf("denial %q does not report the broad scope", denial)
+			}
+		})
+	}
+}